@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultControlSocket = "/var/run/minit.sock"
+	DefaultLogsLines     = 100
+)
+
+// SetupControl 在 Unix 套接字上启动控制接口，供 minit ctl status, minit ctl restart 等子命令使用
+func SetupControl(m *Manager) (err error) {
+	if optControlSocket == "" {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(optControlSocket), 0755); err != nil {
+		err = fmt.Errorf("无法创建控制套接字目录: %s: %s", optControlSocket, err.Error())
+		return
+	}
+	// 删除上次运行遗留的套接字文件
+	_ = os.Remove(optControlSocket)
+
+	var l net.Listener
+	if l, err = net.Listen("unix", optControlSocket); err != nil {
+		err = fmt.Errorf("无法监听控制套接字: %s: %s", optControlSocket, err.Error())
+		return
+	}
+	if err = os.Chmod(optControlSocket, 0600); err != nil {
+		_ = l.Close()
+		err = fmt.Errorf("无法设置控制套接字权限: %s: %s", optControlSocket, err.Error())
+		return
+	}
+
+	log.Printf("启动控制接口: %s", optControlSocket)
+
+	go func() {
+		if err := http.Serve(l, newControlHandler(m)); err != nil {
+			log.Errorf("控制接口退出: %s", err.Error())
+		}
+	}()
+	return
+}
+
+func newControlHandler(m *Manager) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/units", func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(m.Status())
+	})
+	mux.HandleFunc("/units/", func(rw http.ResponseWriter, req *http.Request) {
+		splits := strings.Split(strings.TrimPrefix(req.URL.Path, "/units/"), "/")
+		if len(splits) != 2 {
+			http.NotFound(rw, req)
+			return
+		}
+		name, action := splits[0], splits[1]
+
+		if action == "logs" {
+			if req.Method != http.MethodGet {
+				rw.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			serveControlLogs(m, name, rw, req)
+			return
+		}
+
+		if action == "history" {
+			if req.Method != http.MethodGet {
+				rw.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			records, err := m.UnitHistory(name)
+			if err != nil {
+				writeControlError(rw, err)
+				return
+			}
+			rw.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(rw).Encode(records)
+			return
+		}
+
+		var fn func(name string) error
+		switch action {
+		case "start":
+			fn = m.StartUnit
+		case "stop":
+			fn = m.StopUnit
+		case "restart":
+			fn = m.RestartUnit
+		case "trigger":
+			fn = m.TriggerUnit
+		default:
+			http.NotFound(rw, req)
+			return
+		}
+		if req.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Printf("控制接口: %s %s", action, name)
+
+		if err := fn(name); err != nil {
+			writeControlError(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+func writeControlError(rw http.ResponseWriter, err error) {
+	code := http.StatusConflict
+	if errors.Is(err, ErrUnitNotFound) {
+		code = http.StatusNotFound
+	}
+	http.Error(rw, err.Error(), code)
+}
+
+func serveControlLogs(m *Manager, name string, rw http.ResponseWriter, req *http.Request) {
+	logger, err := m.UnitLogger(name)
+	if err != nil {
+		writeControlError(rw, err)
+		return
+	}
+
+	lines := DefaultLogsLines
+	if val := req.URL.Query().Get("lines"); val != "" {
+		if lines, err = strconv.Atoi(val); err != nil {
+			http.Error(rw, "无效的参数 lines: "+val, http.StatusBadRequest)
+			return
+		}
+	}
+	follow, _ := strconv.ParseBool(req.URL.Query().Get("follow"))
+
+	// 同时取得最近的日志并订阅，避免遗漏或者重复
+	var (
+		recent [][]byte
+		ch     <-chan []byte
+		cancel func()
+	)
+	if follow {
+		recent, ch, cancel = logger.SubscribeRecent(lines)
+		defer cancel()
+	} else {
+		recent = logger.Recent(lines)
+	}
+
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range recent {
+		if _, err = rw.Write(line); err != nil {
+			return
+		}
+	}
+
+	if !follow {
+		return
+	}
+
+	flusher, _ := rw.(http.Flusher)
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case line := <-ch:
+			if _, err = rw.Write(line); err != nil {
+				return
+			}
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ControlPrefix 通过控制套接字与正在运行的 minit 交互的子命令的前缀，避免与主程序的名称冲突，比如 minit ctl status
+const ControlPrefix = "ctl"
+
+// Subcommands 子命令，validate 检查配置单元，schema 输出配置单元的 JSON Schema，ctl 之后为控制子命令
+var Subcommands = map[string]func(args []string) error{
+	"validate":    runValidateCommand,
+	"schema":      runSchemaCommand,
+	ControlPrefix: runControlCommand,
+}
+
+// ControlSubcommands 控制子命令，通过控制套接字与正在运行的 minit 交互
+var ControlSubcommands = map[string]func(args []string) error{
+	"status":  runStatusCommand,
+	"start":   newUnitActionCommand("start", "已启动"),
+	"stop":    newUnitActionCommand("stop", "已停止"),
+	"restart": newUnitActionCommand("restart", "已重启"),
+	"trigger": newUnitActionCommand("trigger", "已触发"),
+	"logs":    runLogsCommand,
+	"history": runHistoryCommand,
+}
+
+// findSubcommand 查找子命令，dashed 表示参数位于 "--" 之后，此时总是作为主程序
+func findSubcommand(args []string, dashed bool) (name string, rest []string, ok bool) {
+	if len(args) == 0 || dashed {
+		return
+	}
+	if name = args[0]; Subcommands[name] == nil {
+		return
+	}
+	rest = args[1:]
+	ok = true
+	return
+}
+
+// runSubcommand 执行子命令并退出
+func runSubcommand(name string, args []string) {
+	if err := Subcommands[name](args); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "错误: %s\n", err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// runControlCommand 执行 ctl 之后的控制子命令
+func runControlCommand(args []string) error {
+	if len(args) == 0 || ControlSubcommands[args[0]] == nil {
+		names := make([]string, 0, len(ControlSubcommands))
+		for name := range ControlSubcommands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		_, _ = fmt.Fprintf(os.Stderr, "用法: minit %s <%s>\n", ControlPrefix, strings.Join(names, "|"))
+		os.Exit(2)
+	}
+	return ControlSubcommands[args[0]](args[1:])
+}
+
+func newSubcommandFlagSet(name string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&optControlSocket, "control-socket", optControlSocket, "控制套接字路径")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "用法: minit %s\n", usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func newControlClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", optControlSocket)
+			},
+		},
+	}
+}
+
+func controlRequest(client *http.Client, method string, path string) (res *http.Response, err error) {
+	var req *http.Request
+	if req, err = http.NewRequest(method, "http://minit"+path, nil); err != nil {
+		return
+	}
+	if res, err = client.Do(req); err != nil {
+		err = fmt.Errorf("无法连接控制套接字 %s: %s", optControlSocket, err.Error())
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		buf, _ := ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		err = fmt.Errorf("%s", strings.TrimSpace(string(buf)))
+		return
+	}
+	return
+}
+
+func runStatusCommand(args []string) (err error) {
+	fs := newSubcommandFlagSet("status", ControlPrefix+" status [--json]")
+	optJSON := fs.Bool("json", false, "以 JSON 格式输出")
+	_ = fs.Parse(args)
+
+	var res *http.Response
+	if res, err = controlRequest(newControlClient(time.Second*10), http.MethodGet, "/units"); err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	var units []UnitStatusSnapshot
+	if err = json.NewDecoder(res.Body).Decode(&units); err != nil {
+		return
+	}
+
+	if *optJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(units)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tKIND\tPHASE\tPID\tRESTARTS\tSINCE\tERROR")
+	for _, u := range units {
+		pid, since := "-", "-"
+		if u.PID != 0 {
+			pid = strconv.Itoa(u.PID)
+		}
+		if !u.Since.IsZero() {
+			since = time.Since(u.Since).Truncate(time.Second).String()
+		}
+		_, _ = fmt.Fprintf(
+			w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			u.Name, u.Kind, u.Phase, pid, u.Restarts, since, u.Error,
+		)
+	}
+	return w.Flush()
+}
+
+func newUnitActionCommand(action string, done string) func(args []string) error {
+	return func(args []string) (err error) {
+		fs := newSubcommandFlagSet(action, ControlPrefix+" "+action+" <unit>")
+		_ = fs.Parse(args)
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		name := fs.Arg(0)
+
+		// stop 和 restart 需要等待进程退出，不设置超时
+		var res *http.Response
+		if res, err = controlRequest(newControlClient(0), http.MethodPost, "/units/"+url.PathEscape(name)+"/"+action); err != nil {
+			return
+		}
+		_ = res.Body.Close()
+
+		_, _ = fmt.Fprintf(os.Stdout, "%s %s\n", done, name)
+		return
+	}
+}
+
+func runHistoryCommand(args []string) (err error) {
+	fs := newSubcommandFlagSet("history", ControlPrefix+" history [--json] <unit>")
+	optJSON := fs.Bool("json", false, "以 JSON 格式输出")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var res *http.Response
+	if res, err = controlRequest(newControlClient(time.Second*10), http.MethodGet, "/units/"+url.PathEscape(fs.Arg(0))+"/history"); err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	var records []RunRecord
+	if err = json.NewDecoder(res.Body).Decode(&records); err != nil {
+		return
+	}
+
+	if *optJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "START\tTRIGGER\tDURATION\tEXIT\tERROR")
+	for _, rec := range records {
+		duration, exit := rec.Duration.Truncate(time.Millisecond).String(), strconv.Itoa(rec.ExitCode)
+		if rec.Skipped {
+			duration, exit = "-", "skipped"
+		}
+		_, _ = fmt.Fprintf(
+			w, "%s\t%s\t%s\t%s\t%s\n",
+			rec.Start.Format("2006-01-02 15:04:05"), rec.Trigger, duration, exit, rec.Error,
+		)
+	}
+	return w.Flush()
+}
+
+func runLogsCommand(args []string) (err error) {
+	fs := newSubcommandFlagSet("logs", ControlPrefix+" logs [-f] [-n lines] <unit>")
+	optFollow := fs.Bool("f", false, "持续输出新的日志")
+	optLines := fs.Int("n", DefaultLogsLines, "输出最近的日志行数，-1 表示全部")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	query := url.Values{}
+	query.Set("lines", strconv.Itoa(*optLines))
+	query.Set("follow", strconv.FormatBool(*optFollow))
+
+	var res *http.Response
+	if res, err = controlRequest(newControlClient(0), http.MethodGet, "/units/"+url.PathEscape(fs.Arg(0))+"/logs?"+query.Encode()); err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(os.Stdout, res.Body)
+	return
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+const (
+	groupFile = "/etc/group"
+)
+
+// Credential 运行进程使用的用户和组
+type Credential struct {
+	Uid    uint32
+	Gid    uint32
+	Groups []uint32
+
+	Username string
+	Home     string
+}
+
+// Env 返回与用户对应的 HOME, USER, LOGNAME 环境变量，没有指定用户时返回 nil
+func (c *Credential) Env() []string {
+	if c == nil || c.Username == "" {
+		return nil
+	}
+	return []string{
+		"HOME=" + c.Home,
+		"USER=" + c.Username,
+		"LOGNAME=" + c.Username,
+	}
+}
+
+func parseID(s string) (id uint32, ok bool) {
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return
+	}
+	return uint32(v), true
+}
+
+// lookupGroupID 按照名称或者数字 ID 查找组，数字 ID 不存在于 /etc/group 中时直接使用
+func lookupGroupID(name string) (gid uint32, err error) {
+	if id, ok := parseID(name); ok {
+		return id, nil
+	}
+	var g *user.Group
+	if g, err = user.LookupGroup(name); err != nil {
+		err = fmt.Errorf("找不到组 %s", name)
+		return
+	}
+	if gid, ok := parseID(g.Gid); ok {
+		return gid, nil
+	}
+	err = fmt.Errorf("组 %s 的 ID 无效: %s", name, g.Gid)
+	return
+}
+
+// lookupUserGroups 从 /etc/group 中查找用户所属的附加组
+func lookupUserGroups(username string) (gids []uint32) {
+	f, err := os.Open(groupFile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// name:password:gid:user1,user2
+		splits := strings.Split(line, ":")
+		if len(splits) < 4 {
+			continue
+		}
+		gid, ok := parseID(splits[2])
+		if !ok {
+			continue
+		}
+		for _, member := range strings.Split(splits[3], ",") {
+			if strings.TrimSpace(member) == username {
+				gids = append(gids, gid)
+				break
+			}
+		}
+	}
+	return
+}
+
+// resolveCredential 根据 user 和 supplementary_groups 字段，从 /etc/passwd 和 /etc/group 中解析用户和组，
+// 均未指定时返回 nil，即使用 minit 自身的用户
+func resolveCredential(opts ExecuteOptions) (cred *Credential, err error) {
+	if opts.User == "" && len(opts.SupplementaryGroups) == 0 {
+		return
+	}
+
+	// user:group
+	username, group := strings.TrimSpace(opts.User), ""
+	if idx := strings.Index(username, ":"); idx >= 0 {
+		username, group = strings.TrimSpace(username[:idx]), strings.TrimSpace(username[idx+1:])
+	}
+
+	cred = &Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+
+	// 用户，数字 ID 不存在于 /etc/passwd 中时，使用 gid 0 和 HOME=/，与 docker 的行为一致
+	if username != "" {
+		var u *user.User
+		if id, ok := parseID(username); ok {
+			if u, err = user.LookupId(username); err != nil {
+				err = nil
+				cred.Uid, cred.Gid = id, 0
+				cred.Username, cred.Home = username, "/"
+			}
+		} else if u, err = user.Lookup(username); err != nil {
+			err = fmt.Errorf("找不到用户 %s，检查 user 字段", username)
+			return
+		}
+		if u != nil {
+			var ok bool
+			if cred.Uid, ok = parseID(u.Uid); !ok {
+				err = fmt.Errorf("用户 %s 的 ID 无效: %s", username, u.Uid)
+				return
+			}
+			if cred.Gid, ok = parseID(u.Gid); !ok {
+				err = fmt.Errorf("用户 %s 的组 ID 无效: %s", username, u.Gid)
+				return
+			}
+			cred.Username, cred.Home = u.Username, u.HomeDir
+			if cred.Home == "" {
+				cred.Home = "/"
+			}
+		}
+	}
+
+	// 主组，默认为用户的主组
+	if group != "" {
+		if cred.Gid, err = lookupGroupID(group); err != nil {
+			err = fmt.Errorf("%s，检查 user 字段", err.Error())
+			return
+		}
+	}
+
+	// 附加组，默认为 /etc/group 中用户所属的组
+	if len(opts.SupplementaryGroups) > 0 {
+		for _, name := range opts.SupplementaryGroups {
+			var gid uint32
+			if gid, err = lookupGroupID(strings.TrimSpace(name)); err != nil {
+				err = fmt.Errorf("%s，检查 supplementary_groups 字段", err.Error())
+				return
+			}
+			cred.Groups = append(cred.Groups, gid)
+		}
+	} else if cred.Username != "" {
+		cred.Groups = lookupUserGroups(cred.Username)
+	}
+
+	return
+}
+
+// parseUmask 解析八进制的 umask，比如 0022
+func parseUmask(s string) (mask int, err error) {
+	var v uint64
+	if v, err = strconv.ParseUint(strings.TrimSpace(s), 8, 32); err != nil || v > 0777 {
+		err = fmt.Errorf("无效的 umask: %s，检查 umask 字段", s)
+		return
+	}
+	mask = int(v)
+	return
+}
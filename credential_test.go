@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestResolveCredential(t *testing.T) {
+	cred, err := resolveCredential(ExecuteOptions{})
+	require.NoError(t, err)
+	require.Nil(t, cred)
+
+	cred, err = resolveCredential(ExecuteOptions{User: "54321:54322", SupplementaryGroups: []string{"54323"}})
+	require.NoError(t, err)
+	require.Equal(t, uint32(54321), cred.Uid)
+	require.Equal(t, uint32(54322), cred.Gid)
+	require.Equal(t, []uint32{54323}, cred.Groups)
+	require.Equal(t, []string{"HOME=/", "USER=54321", "LOGNAME=54321"}, cred.Env())
+
+	_, err = resolveCredential(ExecuteOptions{User: "minit-no-such-user"})
+	require.Error(t, err)
+	_, err = resolveCredential(ExecuteOptions{User: "54321:minit-no-such-group"})
+	require.Error(t, err)
+}
+
+func TestParseUmask(t *testing.T) {
+	mask, err := parseUmask("0027")
+	require.NoError(t, err)
+	require.Equal(t, 0027, mask)
+	_, err = parseUmask("0899")
+	require.Error(t, err)
+	_, err = parseUmask("1777")
+	require.Error(t, err)
+}
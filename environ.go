@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/guoyk93/minit/pkg/shellquote"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// splitEnvEntry 拆分 KEY=VALUE 格式的环境变量
+func splitEnvEntry(entry string) (key string, val string, ok bool) {
+	splits := strings.SplitN(entry, "=", 2)
+	if len(splits) != 2 {
+		return
+	}
+	return splits[0], splits[1], true
+}
+
+// inheritEnv 检查是否继承 minit 的环境变量，pass_env 支持通配符
+func inheritEnv(opts ExecuteOptions, key string) bool {
+	if !opts.ClearEnv && len(opts.PassEnv) == 0 {
+		return true
+	}
+	for _, pattern := range opts.PassEnv {
+		if ok, _ := filepath.Match(strings.TrimSpace(pattern), key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// expandEnv 按照 shell 的规则展开变量，支持 ${VAR:-default}, ${VAR:?message}, ${VAR:+alt} 等形式
+func expandEnv(s string, env map[string]string) (string, error) {
+	return shellquote.Expand(s, func(key string) (string, bool) {
+		val, ok := env[key]
+		return val, ok
+	})
+}
+
+// isExpandError 检查是否为变量展开造成的错误，比如 ${VAR:?message} 中的变量未设置
+func isExpandError(err error) bool {
+	var (
+		pe *shellquote.ParameterError
+		be *shellquote.BadSubstitutionError
+	)
+	return errors.As(err, &pe) || errors.As(err, &be) || errors.Is(err, shellquote.UnterminatedBraceError)
+}
+
+// checkExpandFiles 载入时检查 files 字段的变量展开，环境变量无法计算时跳过检查
+func checkExpandFiles(opts ExecuteOptions, files []string) error {
+	env, err := resolveEnviron(opts, nil)
+	if err != nil {
+		if isExpandError(err) {
+			return err
+		}
+		return nil
+	}
+	if _, err = expandEnvs(files, env); err != nil {
+		return fmt.Errorf("无法展开 files 字段: %s", err.Error())
+	}
+	return nil
+}
+
+// expandEnvs 展开多个字符串
+func expandEnvs(items []string, env map[string]string) (out []string, err error) {
+	out = make([]string, 0, len(items))
+	for _, item := range items {
+		if item, err = expandEnv(item, env); err != nil {
+			return
+		}
+		out = append(out, item)
+	}
+	return
+}
+
+// unquoteEnvValue 处理 dotenv 文件中的值，单引号内的值原样保留，双引号内的值支持转义和变量引用，未加引号的值支持行尾注释和变量引用
+func unquoteEnvValue(val string, env map[string]string) (string, error) {
+	val = strings.TrimSpace(val)
+	switch {
+	case strings.HasPrefix(val, "'"):
+		if len(val) < 2 || !strings.HasSuffix(val, "'") {
+			return "", fmt.Errorf("单引号没有闭合")
+		}
+		return val[1 : len(val)-1], nil
+	case strings.HasPrefix(val, `"`):
+		if len(val) < 2 || !strings.HasSuffix(val, `"`) {
+			return "", fmt.Errorf("双引号没有闭合")
+		}
+		val = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(val[1 : len(val)-1])
+		return expandEnv(val, env)
+	default:
+		if idx := strings.Index(val, " #"); idx >= 0 {
+			val = strings.TrimSpace(val[:idx])
+		}
+		return expandEnv(val, env)
+	}
+}
+
+// loadEnvFile 读取 dotenv 格式的文件，写入 env，值中可以引用已经存在的环境变量
+func loadEnvFile(file string, env map[string]string) (err error) {
+	var f *os.File
+	if f, err = os.Open(file); err != nil {
+		return
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	var num int
+	for s.Scan() {
+		num++
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, val, ok := splitEnvEntry(line)
+		if key = strings.TrimSpace(key); !ok || key == "" {
+			return fmt.Errorf("环境变量文件 %s 第 %d 行格式错误", file, num)
+		}
+		if val, err = unquoteEnvValue(val, env); err != nil {
+			return fmt.Errorf("环境变量文件 %s 第 %d 行格式错误: %w", file, num, err)
+		}
+		env[key] = val
+	}
+	return s.Err()
+}
+
+// trimEnviron 去除环境变量名称和值两端的空白，render 单元的 .Env 一直如此处理
+func trimEnviron(env map[string]string) map[string]string {
+	out := map[string]string{}
+	for key, val := range env {
+		out[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return out
+}
+
+// resolveEnviron 计算单元的环境变量，依次为继承的环境变量，运行用户对应的 HOME, USER, LOGNAME，env_file 文件，env 字段
+func resolveEnviron(opts ExecuteOptions, cred *Credential) (env map[string]string, err error) {
+	env = map[string]string{}
+
+	for _, entry := range os.Environ() {
+		if key, val, ok := splitEnvEntry(entry); ok && inheritEnv(opts, key) {
+			env[key] = val
+		}
+	}
+
+	for _, entry := range cred.Env() {
+		if key, val, ok := splitEnvEntry(entry); ok {
+			env[key] = val
+		}
+	}
+
+	// 以 - 开头的文件不存在时忽略
+	for _, file := range opts.EnvFile {
+		file = strings.TrimSpace(file)
+		optional := strings.HasPrefix(file, "-")
+		file = strings.TrimPrefix(file, "-")
+		if err = loadEnvFile(file, env); err != nil {
+			if optional && os.IsNotExist(err) {
+				err = nil
+				continue
+			}
+			err = fmt.Errorf("无法载入环境变量文件，检查 env_file 字段: %w", err)
+			return
+		}
+	}
+
+	// env 字段中的值只能引用此前的环境变量，不能引用 env 字段中的其他变量
+	values := map[string]string{}
+	for key, val := range opts.Env {
+		if values[key], err = expandEnv(val, env); err != nil {
+			err = fmt.Errorf("无法展开环境变量 %s，检查 env 字段: %w", key, err)
+			return
+		}
+	}
+	for key, val := range values {
+		env[key] = val
+	}
+	return
+}
+
+// environList 将环境变量转换为 KEY=VALUE 列表，按照名称排序
+func environList(env map[string]string) []string {
+	out := make([]string, 0, len(env))
+	for key, val := range env {
+		out = append(out, key+"="+val)
+	}
+	sort.Strings(out)
+	return out
+}
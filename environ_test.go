@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveEnviron(t *testing.T) {
+	defer os.Unsetenv("MINIT_TEST_SECRET")
+	defer os.Unsetenv("MINIT_TEST_LANG")
+	_ = os.Setenv("MINIT_TEST_SECRET", "secret")
+	_ = os.Setenv("MINIT_TEST_LANG", "C")
+
+	env, err := resolveEnviron(ExecuteOptions{
+		EnvFile: []string{
+			filepath.Join("testdata", "env", "app.env"),
+			"-" + filepath.Join("testdata", "env", "missing.env"),
+		},
+		Env: map[string]string{
+			"DB_PORT": "6543",
+			"DB_ADDR": "$DB_HOST:$DB_PORT",
+		},
+	}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "secret", env["MINIT_TEST_SECRET"])
+	require.Equal(t, "db.local", env["DB_HOST"])
+	require.Equal(t, "6543", env["DB_PORT"])
+	require.Equal(t, "db.local:5432", env["DB_ADDR"])
+	require.Equal(t, "postgres://db.local:5432/app\tx", env["DB_URL"])
+	require.Equal(t, "p@$$word", env["DB_PASS"])
+
+	env, err = resolveEnviron(ExecuteOptions{PassEnv: []string{"MINIT_TEST_L*"}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"MINIT_TEST_LANG": "C"}, env)
+
+	env, err = resolveEnviron(ExecuteOptions{ClearEnv: true, Env: map[string]string{"A": "1"}}, &Credential{Username: "app", Home: "/home/app"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"A": "1", "HOME": "/home/app", "USER": "app", "LOGNAME": "app"}, env)
+
+	_, err = resolveEnviron(ExecuteOptions{EnvFile: []string{filepath.Join("testdata", "env", "missing.env")}}, nil)
+	require.Error(t, err)
+}
+
+func TestTrimEnviron(t *testing.T) {
+	require.Equal(t, map[string]string{"A": "1", "B": ""}, trimEnviron(map[string]string{" A": " 1 ", "B": "\t"}))
+}
+
+func TestExpandCommand(t *testing.T) {
+	env := map[string]string{"PORT": "9090", "HOME": "/root"}
+	argv, dir, err := expandCommand(ExecuteOptions{
+		Dir:     "${APP_DIR:-$HOME/app}",
+		Command: []string{"server", "--port", "${PORT:-8080}", "${DEBUG:+--debug}"},
+	}, env)
+	require.NoError(t, err)
+	require.Equal(t, "/root/app", dir)
+	require.Equal(t, []string{"server", "--port", "9090", ""}, argv)
+
+	_, _, err = expandCommand(ExecuteOptions{Command: []string{"${API_KEY:?required}"}}, env)
+	require.Error(t, err)
+
+	err = checkExecuteOptions(ExecuteOptions{Command: []string{"${API_KEY:?required}"}})
+	require.Error(t, err)
+	err = checkExecuteOptions(ExecuteOptions{Command: []string{"${API_KEY:?required}"}, Env: map[string]string{"API_KEY": "x"}})
+	require.NoError(t, err)
+}
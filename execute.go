@@ -1,14 +1,44 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/guoyk93/minit/pkg/mlog"
 	"github.com/guoyk93/minit/pkg/shellquote"
 	"io"
-	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultStopSignal  = syscall.SIGTERM
+	DefaultStopTimeout = time.Second * 10
+
+	StreamDrainTimeout = time.Second
+
+	ExitCodeTimeout = 124
+)
+
+var (
+	knownSignalNames = map[string]syscall.Signal{
+		"ABRT":  syscall.SIGABRT,
+		"ALRM":  syscall.SIGALRM,
+		"HUP":   syscall.SIGHUP,
+		"INT":   syscall.SIGINT,
+		"KILL":  syscall.SIGKILL,
+		"PIPE":  syscall.SIGPIPE,
+		"QUIT":  syscall.SIGQUIT,
+		"TERM":  syscall.SIGTERM,
+		"USR1":  syscall.SIGUSR1,
+		"USR2":  syscall.SIGUSR2,
+		"WINCH": syscall.SIGWINCH,
+	}
 )
 
 var (
@@ -17,9 +47,126 @@ var (
 )
 
 type ExecuteOptions struct {
-	Dir     string   `yaml:"dir"`     // 所有涉及命令执行的单元，指定命令执行时的当前目录
-	Shell   string   `yaml:"shell"`   // 使用 shell 来执行命令，比如 'bash'
-	Command []string `yaml:"command"` // 所有涉及命令执行的单元，指定命令执行的内容
+	Dir     string   `yaml:"dir" kind:"once,daemon,cron,timer,logrotate"`     // 所有涉及命令执行的单元，指定命令执行时的当前目录
+	Shell   string   `yaml:"shell" kind:"once,daemon,cron,timer,logrotate"`   // 使用 shell 来执行命令，比如 'bash'
+	Command []string `yaml:"command" kind:"once,daemon,cron,timer,logrotate"` // 所有涉及命令执行的单元，指定命令执行的内容
+
+	StopSignal  string        `yaml:"stop_signal" kind:"once,daemon,cron,timer,logrotate"`  // 停止进程时，向进程组发送的信号，比如 SIGQUIT，默认 SIGTERM
+	StopTimeout time.Duration `yaml:"stop_timeout" kind:"once,daemon,cron,timer,logrotate"` // 发送停止信号后，等待进程退出的时间，超时后向进程组发送 SIGKILL，默认 10s
+	Timeout     time.Duration `yaml:"timeout" kind:"once,cron,timer,logrotate"`             // 命令执行的最长时间，超时后按照 stop_signal 和 stop_timeout 停止进程组，默认不限制
+
+	User                string   `yaml:"user" kind:"once,daemon,cron,timer,logrotate"`                 // 运行进程的用户和组，格式为 user 或者 user:group，名称或者数字 ID，组默认为用户的主组
+	SupplementaryGroups []string `yaml:"supplementary_groups" kind:"once,daemon,cron,timer,logrotate"` // 附加组，名称或者数字 ID，默认为 /etc/group 中用户所属的组
+	Umask               string   `yaml:"umask" kind:"once,daemon,cron,timer,logrotate"`                // 进程的 umask，八进制，比如 0027
+
+	Env      map[string]string `yaml:"env"`       // 额外的环境变量，值中可以引用继承的环境变量和 env_file 中的环境变量
+	EnvFile  []string          `yaml:"env_file"`  // dotenv 格式的环境变量文件，按顺序载入，以 - 开头表示文件不存在时忽略
+	ClearEnv bool              `yaml:"clear_env"` // 不继承 minit 的环境变量
+	PassEnv  []string          `yaml:"pass_env"`  // 只继承指定的 minit 环境变量，支持通配符，比如 LANG, LC_*
+}
+
+// ExitError 进程以非零状态退出
+type ExitError struct {
+	Status syscall.WaitStatus
+}
+
+func (e *ExitError) Error() string {
+	return formatWaitStatus(e.Status)
+}
+
+// ExitCode 返回退出码，被信号终止时，按照 shell 的惯例返回 128 + 信号值
+func (e *ExitError) ExitCode() int {
+	if e.Status.Signaled() {
+		return 128 + int(e.Status.Signal())
+	}
+	return e.Status.ExitStatus()
+}
+
+// TimeoutError 进程执行超时，被 minit 停止
+type TimeoutError struct {
+	Timeout time.Duration
+	Status  syscall.WaitStatus
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("执行超时 (%s)，%s", e.Timeout.String(), formatWaitStatus(e.Status))
+}
+
+// ExitCode 返回退出码，按照 timeout 命令的惯例返回 124
+func (e *TimeoutError) ExitCode() int {
+	return ExitCodeTimeout
+}
+
+// exitCodeOf 返回错误对应的退出码，没有错误时返回 0，不是由进程退出造成的错误返回 1
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	var te *TimeoutError
+	if errors.As(err, &te) {
+		return te.ExitCode()
+	}
+	var ee *ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	return 1
+}
+
+func parseSignal(s string) (sig syscall.Signal, err error) {
+	name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "SIG")
+	if sig = knownSignalNames[name]; sig != 0 {
+		return
+	}
+	var num int
+	if num, err = strconv.Atoi(name); err != nil || num <= 0 {
+		err = fmt.Errorf("未知的信号: %s", s)
+		return
+	}
+	sig = syscall.Signal(num)
+	return
+}
+
+// checkExecuteOptions 检查命令执行相关的字段
+func checkExecuteOptions(opts ExecuteOptions) (err error) {
+	if opts.StopSignal != "" {
+		if _, err = parseSignal(opts.StopSignal); err != nil {
+			err = fmt.Errorf("%s，检查 stop_signal 字段", err.Error())
+			return
+		}
+	}
+	if opts.StopTimeout < 0 {
+		err = fmt.Errorf("停止等待时间不能为负数，检查 stop_timeout 字段")
+		return
+	}
+	if opts.Timeout < 0 {
+		err = fmt.Errorf("超时时间不能为负数，检查 timeout 字段")
+		return
+	}
+	if _, err = resolveCredential(opts); err != nil {
+		return
+	}
+	if opts.Umask != "" {
+		if _, err = parseUmask(opts.Umask); err != nil {
+			return
+		}
+	}
+	for _, pattern := range opts.PassEnv {
+		if _, err = filepath.Match(strings.TrimSpace(pattern), ""); err != nil {
+			err = fmt.Errorf("无效的通配符 %s，检查 pass_env 字段", pattern)
+			return
+		}
+	}
+	// 载入时检查变量展开，env_file 可能由 render 单元生成，文件不存在等原因导致环境变量无法计算时跳过检查
+	env, eerr := resolveEnviron(opts, nil)
+	if eerr != nil {
+		if isExpandError(eerr) {
+			err = eerr
+		}
+		return
+	}
+	_, _, err = expandCommand(opts, env)
+	return
 }
 
 func addPid(pid int) {
@@ -34,31 +181,82 @@ func removePid(pid int) {
 	delete(childPids, pid)
 }
 
-func notifyPIDs(sig os.Signal) {
+func notifyPIDs(sig syscall.Signal) {
 	childPidsLock.Lock()
 	defer childPidsLock.Unlock()
 	for pid, found := range childPids {
 		if found {
-			if process, _ := os.FindProcess(pid); process != nil {
-				_ = process.Signal(sig)
-			}
+			_ = signalProcessGroup(pid, sig)
 		}
 	}
 }
 
-func execute(opts ExecuteOptions, logger *mlog.Logger) (err error) {
-	argv := make([]string, 0)
+// stopCommand 向进程组发送停止信号，超时后发送 SIGKILL，直到 done 关闭
+func stopCommand(opts ExecuteOptions, pid int, done chan struct{}, logger *mlog.Logger) {
+	sig := DefaultStopSignal
+	if opts.StopSignal != "" {
+		sig, _ = parseSignal(opts.StopSignal)
+	}
+	timeout := opts.StopTimeout
+	if timeout == 0 {
+		timeout = DefaultStopTimeout
+	}
 
-	// 构建 argv
+	logger.Printf("发送信号 %s", sig.String())
+	if err := signalCommand(pid, sig); err != nil {
+		logger.Errorf("无法发送信号 %s: %s", sig.String(), err.Error())
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		logger.Errorf("进程未在 %s 内退出，强制结束进程组", timeout.String())
+		_ = signalCommand(pid, syscall.SIGKILL)
+	}
+}
+
+// expandCommand 构建 argv，展开 command 和 dir 中的变量，使用 shell 时，command 由 shell 处理，不展开
+func expandCommand(opts ExecuteOptions, env map[string]string) (argv []string, dir string, err error) {
 	if opts.Shell != "" {
 		if argv, err = shellquote.Split(opts.Shell); err != nil {
 			err = fmt.Errorf("无法处理 shell 参数，请检查: %s", err.Error())
 			return
 		}
-	} else {
-		for _, arg := range opts.Command {
-			argv = append(argv, os.ExpandEnv(arg))
-		}
+	} else if argv, err = expandEnvs(opts.Command, env); err != nil {
+		err = fmt.Errorf("无法展开 command 字段: %s", err.Error())
+		return
+	}
+	if dir, err = expandEnv(opts.Dir, env); err != nil {
+		err = fmt.Errorf("无法展开 dir 字段: %s", err.Error())
+		return
+	}
+	return
+}
+
+// execute 执行命令，直到进程退出，ctx 结束时停止进程组，onStart 可以为 nil
+func execute(ctx context.Context, opts ExecuteOptions, logger *mlog.Logger, onStart func(pid int)) (err error) {
+	// 用户和组
+	var cred *Credential
+	if cred, err = resolveCredential(opts); err != nil {
+		return
+	}
+
+	// 环境变量
+	var env map[string]string
+	if env, err = resolveEnviron(opts, cred); err != nil {
+		return
+	}
+
+	// 构建 argv
+	var (
+		argv []string
+		dir  string
+	)
+	if argv, dir, err = expandCommand(opts, env); err != nil {
+		return
 	}
 
 	// 构建 cmd
@@ -67,9 +265,11 @@ func execute(opts ExecuteOptions, logger *mlog.Logger) (err error) {
 	if opts.Shell != "" {
 		cmd.Stdin = strings.NewReader(strings.Join(opts.Command, "\n"))
 	}
-	cmd.Dir = opts.Dir
-	// 阻止信号传递
-	setupCmdSysProcAttr(cmd)
+	cmd.Dir = dir
+	cmd.Env = environList(env)
+
+	// 阻止信号传递，设置用户和组
+	setupCmdSysProcAttr(cmd, cred)
 
 	if outPipe, err = cmd.StdoutPipe(); err != nil {
 		return
@@ -78,28 +278,107 @@ func execute(opts ExecuteOptions, logger *mlog.Logger) (err error) {
 		return
 	}
 
+	// 设置 umask
+	if opts.Umask != "" {
+		var umask int
+		if umask, err = parseUmask(opts.Umask); err != nil {
+			return
+		}
+		setupCmdUmask(cmd, umask)
+	}
+
 	// 执行
-	if err = cmd.Start(); err != nil {
+	if err = startCommand(cmd); err != nil {
 		return
 	}
 
 	// 记录 Pid
-	addPid(cmd.Process.Pid)
+	pid := cmd.Process.Pid
+	addPid(pid)
+
+	if onStart != nil {
+		onStart(pid)
+	}
 
 	// 串流
-	go logger.StreamOut(outPipe)
-	go logger.StreamErr(errPipe)
+	streams := &sync.WaitGroup{}
+	streams.Add(2)
+	go func() {
+		defer streams.Done()
+		logger.StreamOut(pid, outPipe)
+	}()
+	go func() {
+		defer streams.Done()
+		logger.StreamErr(pid, errPipe)
+	}()
+
+	// ctx 结束或者执行超时时，停止进程组
+	var timeoutC <-chan time.Time
+	if opts.Timeout > 0 {
+		timer := time.NewTimer(opts.Timeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+
+	var timedOut bool
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-done:
+		case <-ctx.Done():
+			stopCommand(opts, pid, done, logger)
+		case <-timeoutC:
+			timedOut = true
+			logger.Errorf("进程执行超过 %s，停止进程组", opts.Timeout.String())
+			stopCommand(opts, pid, done, logger)
+		}
+	}()
 
 	// 等待退出
-	if err = cmd.Wait(); err != nil {
+	var ws syscall.WaitStatus
+	if ws, err = waitCommand(cmd); err != nil {
+		err = fmt.Errorf("无法等待进程退出: %s", err.Error())
 		logger.Errorf("进程退出: %s", err.Error())
-		err = nil
-	} else {
+	} else if ws.Exited() && ws.ExitStatus() == 0 {
 		logger.Printf("进程退出")
+	} else {
+		err = &ExitError{Status: ws}
+		logger.Errorf("进程退出: %s", err.Error())
+	}
+
+	// 等待输出读取完毕，如果后台进程仍然持有输出管道，超时后直接关闭
+	chStreams := make(chan struct{})
+	go func() {
+		streams.Wait()
+		close(chStreams)
+	}()
+	select {
+	case <-chStreams:
+	case <-time.After(StreamDrainTimeout):
+	}
+	_ = outPipe.Close()
+	_ = errPipe.Close()
+
+	close(done)
+	<-stopped
+
+	// 超时被停止的进程，即便正常退出也视为失败
+	if timedOut {
+		err = &TimeoutError{Timeout: opts.Timeout, Status: ws}
+		logger.Errorf("进程执行超时: %s", err.Error())
 	}
 
 	// 移除 Pid
-	removePid(cmd.Process.Pid)
+	removePid(pid)
 
 	return
 }
+
+func formatWaitStatus(ws syscall.WaitStatus) string {
+	if ws.Signaled() {
+		return fmt.Sprintf("信号 %s", ws.Signal().String())
+	}
+	return fmt.Sprintf("退出码 %d", ws.ExitStatus())
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	DependAfter    = "after"    // 仅影响启动顺序
+	DependWants    = "wants"    // 弱依赖，依赖单元不存在或者失败时，仍然启动
+	DependRequires = "requires" // 强依赖，依赖单元不存在时载入失败，依赖单元失败时不启动
+	DependLevel    = "level"    // 由 L1/L2/L3 级别产生的隐式依赖
+)
+
+// UnitDependency 单元依赖
+type UnitDependency struct {
+	Index int    // 依赖单元在单元列表中的位置
+	Kind  string // 依赖类型
+}
+
+// resolveUnitName 查找单元名称，对于设置了 count 的单元，原始名称对应所有副本
+func resolveUnitName(units []Unit, name string) (indexes []int) {
+	for i, unit := range units {
+		if unit.Name == name {
+			return []int{i}
+		}
+	}
+	for i, unit := range units {
+		if unit.baseName != "" && unit.baseName == name {
+			indexes = append(indexes, i)
+		}
+	}
+	return
+}
+
+func hasDependency(deps []UnitDependency, index int) bool {
+	for _, dep := range deps {
+		if dep.Index == index {
+			return true
+		}
+	}
+	return false
+}
+
+// dependencyReachable 检查 from 是否直接或者间接依赖 to
+func dependencyReachable(deps [][]UnitDependency, from, to int) bool {
+	visited := map[int]bool{}
+	stack := []int{from}
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if cur == to {
+			return true
+		}
+		if visited[cur] {
+			continue
+		}
+		visited[cur] = true
+		for _, dep := range deps[cur] {
+			stack = append(stack, dep.Index)
+		}
+	}
+	return false
+}
+
+// findDependencyCycle 查找依赖环，返回环上的单元位置
+func findDependencyCycle(deps [][]UnitDependency) []int {
+	const (
+		white = iota
+		gray
+		black
+	)
+	colors := make([]int, len(deps))
+	var path []int
+
+	var visit func(i int) []int
+	visit = func(i int) []int {
+		colors[i] = gray
+		path = append(path, i)
+		for _, dep := range deps[i] {
+			switch colors[dep.Index] {
+			case gray:
+				for j, p := range path {
+					if p == dep.Index {
+						return append(append([]int{}, path[j:]...), dep.Index)
+					}
+				}
+			case white:
+				if cycle := visit(dep.Index); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		colors[i] = black
+		return nil
+	}
+
+	for i := range deps {
+		if colors[i] == white {
+			if cycle := visit(i); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// BuildDependencies 根据 after, wants, requires 字段，以及单元级别，构建依赖关系
+//
+// 低级别的单元先于高级别的单元启动，L1 和 L2 单元按照载入顺序依次执行，除非与显式声明的依赖冲突
+func BuildDependencies(units []Unit, levels []RunnerLevel) (deps [][]UnitDependency, warnings []string, err error) {
+	deps = make([][]UnitDependency, len(units))
+
+	// 显式依赖
+	for i, unit := range units {
+		for _, item := range []struct {
+			kind  string
+			names []string
+		}{
+			{kind: DependRequires, names: unit.Requires},
+			{kind: DependWants, names: unit.Wants},
+			{kind: DependAfter, names: unit.After},
+		} {
+			for _, name := range item.names {
+				name = strings.TrimSpace(name)
+				indexes := resolveUnitName(units, name)
+				if len(indexes) == 0 {
+					switch item.kind {
+					case DependRequires:
+						err = fmt.Errorf("单元 %s 依赖的单元 %s 不存在，检查 requires 字段", unit.Name, name)
+						return
+					case DependWants:
+						warnings = append(warnings, fmt.Sprintf("单元 %s 依赖的单元 %s 不存在，忽略", unit.Name, name))
+					}
+					continue
+				}
+				for _, index := range indexes {
+					if hasDependency(deps[i], index) {
+						continue
+					}
+					deps[i] = append(deps[i], UnitDependency{Index: index, Kind: item.kind})
+				}
+			}
+		}
+	}
+
+	// 检查依赖环
+	if cycle := findDependencyCycle(deps); cycle != nil {
+		names := make([]string, 0, len(cycle))
+		for _, i := range cycle {
+			names = append(names, units[i].Name)
+		}
+		err = fmt.Errorf("单元存在循环依赖: %s", strings.Join(names, " -> "))
+		return
+	}
+
+	// 级别产生的隐式依赖，跳过会产生依赖环的部分
+	for i := range units {
+		for j := range units {
+			if i == j {
+				continue
+			}
+			if levels[j] > levels[i] {
+				continue
+			}
+			if levels[j] == levels[i] && (levels[i] >= RunnerL3 || j > i) {
+				continue
+			}
+			if hasDependency(deps[i], j) || dependencyReachable(deps, j, i) {
+				continue
+			}
+			deps[i] = append(deps[i], UnitDependency{Index: j, Kind: DependLevel})
+		}
+	}
+
+	return
+}
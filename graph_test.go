@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBuildDependencies(t *testing.T) {
+	units := []Unit{
+		{Name: "render", Kind: "render"},
+		{Name: "once-1", Kind: "once"},
+		{Name: "migrate", Kind: "once", Requires: []string{"db"}},
+		{Name: "db", Kind: "daemon"},
+		{Name: "app", Kind: "daemon", After: []string{"db", "missing"}},
+	}
+	levels := []RunnerLevel{RunnerL1, RunnerL2, RunnerL2, RunnerL3, RunnerL3}
+	deps, warnings, err := BuildDependencies(units, levels)
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+
+	// migrate 依赖 db，因此 db 不再隐式依赖 migrate
+	require.True(t, dependencyReachable(deps, 2, 3))
+	require.False(t, dependencyReachable(deps, 3, 2))
+	require.True(t, dependencyReachable(deps, 3, 1))
+	require.True(t, dependencyReachable(deps, 3, 0))
+
+	// L2 单元按照载入顺序执行
+	require.True(t, dependencyReachable(deps, 2, 1))
+	require.False(t, dependencyReachable(deps, 1, 2))
+
+	// app 同时依赖 db 和 migrate
+	require.True(t, dependencyReachable(deps, 4, 3))
+	require.True(t, dependencyReachable(deps, 4, 2))
+
+	require.Nil(t, findDependencyCycle(deps))
+}
+
+func TestBuildDependenciesErrors(t *testing.T) {
+	_, _, err := BuildDependencies([]Unit{
+		{Name: "a", Kind: "daemon", Requires: []string{"b"}},
+		{Name: "b", Kind: "daemon", After: []string{"c"}},
+		{Name: "c", Kind: "daemon", Wants: []string{"a"}},
+	}, []RunnerLevel{RunnerL3, RunnerL3, RunnerL3})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "a -> b -> c -> a")
+
+	_, _, err = BuildDependencies([]Unit{
+		{Name: "a", Kind: "daemon", Requires: []string{"b"}},
+	}, []RunnerLevel{RunnerL3})
+	require.Error(t, err)
+
+	_, warnings, err := BuildDependencies([]Unit{
+		{Name: "a", Kind: "daemon", Wants: []string{"b"}},
+	}, []RunnerLevel{RunnerL3})
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+}
+
+func TestBuildDependenciesReplicas(t *testing.T) {
+	deps, _, err := BuildDependencies([]Unit{
+		{Name: "worker-1", Kind: "daemon", baseName: "worker"},
+		{Name: "worker-2", Kind: "daemon", baseName: "worker"},
+		{Name: "app", Kind: "daemon", Requires: []string{"worker"}},
+	}, []RunnerLevel{RunnerL3, RunnerL3, RunnerL3})
+	require.NoError(t, err)
+	require.Len(t, deps[2], 2)
+	require.Equal(t, DependRequires, deps[2][0].Kind)
+}
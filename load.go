@@ -1,15 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/guoyk93/minit/pkg/shellquote"
 	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type FilterMode int
@@ -49,6 +54,7 @@ func init() {
 	}
 }
 
+// Unit 单元配置，kind 标签指定字段适用的单元类型，没有 kind 标签的字段适用于所有类型
 type Unit struct {
 	ExecuteOptions `yaml:",inline"`
 
@@ -57,13 +63,52 @@ type Unit struct {
 	Kind  string `yaml:"kind"`  // 单元类型
 	Count int    `yaml:"count"` // 单元副本数量
 
-	Raw bool `yaml:"raw"` // 不对渲染文件进行空白行处理
+	Critical bool `yaml:"critical"` // 关键单元，单元结束或者失败时，minit 停止所有单元，并以单元的退出码退出
 
-	Files []string `yaml:"files"` // render, logrotate, logcollect 单元，通配符指定要处理的文件
+	Log LogOptions `yaml:"log"` // 日志配置，未设置的字段使用全局默认值
 
-	Cron string `yaml:"cron"` // cron 单元, 定时表达式
-	Mode string `yaml:"mode"` // logrotate 单元，模式 daily 或者 size
-	Keep int    `yaml:"keep"` // logrotate 单元，保留天数/份数
+	After    []string `yaml:"after"`    // 在指定单元就绪或者结束之后启动，仅影响启动顺序
+	Wants    []string `yaml:"wants"`    // 弱依赖，在指定单元就绪或者结束之后启动，指定单元不存在或者失败时，仍然启动
+	Requires []string `yaml:"requires"` // 强依赖，在指定单元就绪或者结束之后启动，指定单元不存在时载入失败，失败时不启动
+
+	Raw bool `yaml:"raw" kind:"render"` // 不对渲染文件进行空白行处理
+
+	Files []string `yaml:"files" kind:"render,logrotate,logcollect"` // render, logrotate, logcollect 单元，通配符指定要处理的文件
+
+	Cron string   `yaml:"cron" kind:"cron,logrotate"` // cron 单元, 定时表达式，logrotate 单元，检查文件的时间，默认每分钟检查一次
+	Mode string   `yaml:"mode" kind:"logrotate"`      // logrotate 单元，模式 daily, hourly, filesize 或者 daily-or-filesize，默认 daily
+	Keep int      `yaml:"keep" kind:"logrotate"`      // logrotate 单元，保留天数/份数
+	Size ByteSize `yaml:"size" kind:"logrotate"`      // logrotate 单元，filesize 和 daily-or-filesize 模式下，文件的最大大小，默认 256M
+
+	Concurrency string        `yaml:"concurrency" kind:"cron"`  // cron 单元，上次执行尚未结束时的处理方式 allow, forbid 或者 replace，默认 allow
+	Timezone    string        `yaml:"timezone" kind:"cron"`     // cron 单元，cron 表达式使用的时区，IANA 名称，比如 Asia/Shanghai，默认为容器的时区
+	CronSeconds bool          `yaml:"cron_seconds" kind:"cron"` // cron 单元，cron 表达式包含秒字段，共 6 个字段
+	Jitter      time.Duration `yaml:"jitter" kind:"cron,timer"` // cron, timer 单元，每次执行前随机等待 [0, jitter) 的时间，用于错开多个副本的执行时间
+
+	RunOnStart     bool `yaml:"run_on_start" kind:"cron"`          // cron 单元，启动时立即执行一次
+	CatchUp        bool `yaml:"catch_up" kind:"cron"`              // cron 单元，启动时如果错过了停机期间的定时任务，立即补充执行一次，会持久化执行记录
+	HistoryLimit   int  `yaml:"history_limit" kind:"cron,timer"`   // cron, timer 单元，保留的执行记录数量，默认 10
+	HistoryPersist bool `yaml:"history_persist" kind:"cron,timer"` // cron, timer 单元，将执行记录持久化到日志目录中的 <name>.<kind>.json 文件
+
+	Interval     time.Duration `yaml:"interval" kind:"logcollect,timer"` // logcollect 单元，检查文件的间隔，默认 1s，timer 单元，上次执行结束到下次执行开始的间隔
+	InitialDelay time.Duration `yaml:"initial_delay" kind:"timer"`       // timer 单元，启动后首次执行前的等待时间，默认为 0，立即执行
+
+	OnFailure     string        `yaml:"on_failure" kind:"once"`      // once 单元，失败时的处理方式 continue, abort 或者 retry，默认 continue
+	Retries       int           `yaml:"retries" kind:"once"`         // once 单元，失败后的重试次数，on_failure 为 retry 时默认 3
+	RetryDelay    time.Duration `yaml:"retry_delay" kind:"once"`     // once 单元，初始重试等待时间，默认 1s
+	RetryMaxDelay time.Duration `yaml:"retry_max_delay" kind:"once"` // once 单元，最大重试等待时间，每次重试等待时间翻倍，直到此上限，默认与 retry_delay 相同
+
+	Restart           string        `yaml:"restart" kind:"daemon"`             // daemon 单元，重启策略 always, on-failure 或者 never，默认 always
+	RestartDelay      time.Duration `yaml:"restart_delay" kind:"daemon"`       // daemon 单元，初始重启等待时间，默认 5s
+	RestartMaxDelay   time.Duration `yaml:"restart_max_delay" kind:"daemon"`   // daemon 单元，最大重启等待时间，每次重启等待时间翻倍，直到此上限，默认与 restart_delay 相同
+	RestartResetAfter time.Duration `yaml:"restart_reset_after" kind:"daemon"` // daemon 单元，进程持续运行超过此时间，重置重启等待时间，默认 1m
+	MaxRestarts       int           `yaml:"max_restarts" kind:"daemon"`        // daemon 单元，最大重启次数，超过后单元失败，默认不限制
+	MaxRestartsWindow time.Duration `yaml:"max_restarts_window" kind:"daemon"` // daemon 单元，统计重启次数的时间窗口，默认不限时间
+
+	Readiness *Probe `yaml:"readiness" kind:"daemon"` // daemon 单元，就绪探针，通过后才视为就绪，未指定时进程启动即视为就绪
+	Liveness  *Probe `yaml:"liveness" kind:"daemon"`  // daemon 单元，存活探针，连续失败 failure_threshold 次后，停止进程，并按照重启策略处理
+
+	baseName string // 设置了 count 的单元，副本对应的原始单元名
 }
 
 func (u Unit) CanonicalName() string {
@@ -82,9 +127,10 @@ func LoadArgsMain() (unit Unit, ok bool, err error) {
 		return
 	}
 	unit = Unit{
-		Name:  "arg-main",
-		Group: DefaultGroup,
-		Kind:  "daemon",
+		Name:     "arg-main",
+		Group:    DefaultGroup,
+		Kind:     "daemon",
+		Critical: mainCritical("daemon"),
 		ExecuteOptions: ExecuteOptions{
 			Command: args,
 		},
@@ -93,6 +139,14 @@ func LoadArgsMain() (unit Unit, ok bool, err error) {
 	return
 }
 
+// mainCritical daemon 类型的主程序单元默认为关键单元，可以通过环境变量 MINIT_MAIN_CRITICAL 修改
+func mainCritical(kind string) bool {
+	if critical, err := strconv.ParseBool(strings.TrimSpace(os.Getenv("MINIT_MAIN_CRITICAL"))); err == nil {
+		return critical
+	}
+	return kind == "daemon"
+}
+
 func LoadEnvMain() (unit Unit, ok bool, err error) {
 	cmd := strings.TrimSpace(os.Getenv("MINIT_MAIN"))
 	if cmd == "" {
@@ -109,16 +163,17 @@ func LoadEnvMain() (unit Unit, ok bool, err error) {
 	kind := "daemon"
 	if once, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("MINIT_MAIN_ONCE"))); once {
 		kind = "once"
-		return
 	}
+	// 先按照 shell 的规则拆分，其中的变量与 command 字段一样，在执行时展开，比如 ${PORT:-8080}
 	var command []string
 	if command, err = shellquote.Split(cmd); err != nil {
 		return
 	}
 	unit = Unit{
-		Name:  name,
-		Group: group,
-		Kind:  kind,
+		Name:     name,
+		Group:    group,
+		Kind:     kind,
+		Critical: mainCritical(kind),
 		ExecuteOptions: ExecuteOptions{
 			Command: command,
 			Dir:     strings.TrimSpace(os.Getenv("MINIT_MAIN_DIR")),
@@ -152,29 +207,18 @@ func LoadFile(fn string) (units []Unit, err error) {
 	}
 	defer f.Close()
 
-	dec := yaml.NewDecoder(f)
-	for {
-		var unit Unit
-		if err = dec.Decode(&unit); err != nil {
-			if err == io.EOF {
-				err = nil
-			} else {
-				err = fmt.Errorf("无法解析文件 %s: %s", fn, err.Error())
-			}
-			return
-		}
-
-		// 清理下空格
-		unit.Name = strings.TrimSpace(unit.Name)
-		unit.Kind = strings.TrimSpace(unit.Kind)
-		unit.Cron = strings.TrimSpace(unit.Cron)
-		unit.Dir = strings.TrimSpace(unit.Dir)
-		unit.Group = strings.TrimSpace(unit.Group)
+	var docs []unitDocument
+	if docs, err = decodeUnitDocuments(f); err != nil {
+		err = fmt.Errorf("无法解析文件 %s: %s", fn, err.Error())
+		return
+	}
 
-		// 默认组名
-		if unit.Group == "" {
-			unit.Group = DefaultGroup
+	for _, doc := range docs {
+		if len(doc.Errs) > 0 {
+			err = fmt.Errorf("无法解析文件 %s 第 %d 个文档: %s", fn, doc.Index, doc.Errs[0].Error())
+			return
 		}
+		unit := doc.Unit
 
 		// 打开关闭
 		switch filterMode {
@@ -191,15 +235,122 @@ func LoadFile(fn string) (units []Unit, err error) {
 			}
 		}
 
-		// 重复型
-		if unit.Count > 0 {
-			for i := 0; i < unit.Count; i++ {
-				subUnit := unit
-				subUnit.Name = fmt.Sprintf("%s-%d", unit.Name, i+1)
-				units = append(units, subUnit)
+		units = append(units, expandUnit(unit)...)
+	}
+	return
+}
+
+// unitDocument 文件中的一个文档，Index 从 1 开始
+type unitDocument struct {
+	Index int
+	Unit  Unit
+	Errs  []error
+}
+
+// decodeUnitDocuments 逐个解析文档，检查未知的字段以及不适用于单元类型的字段，跳过空文档
+//
+// 字段错误记录在 unitDocument.Errs 中，不影响其他文档，格式错误时返回 err，不再解析后续文档
+//
+// yaml.v3 的节点用于检查字段和定位行号，字段的值仍然由 yaml.v2 解析，保持原有的规则，比如 yes 和 no 作为布尔值
+func decodeUnitDocuments(r io.Reader) (docs []unitDocument, err error) {
+	var buf []byte
+	if buf, err = ioutil.ReadAll(r); err != nil {
+		return
+	}
+	dec := yamlv3.NewDecoder(bytes.NewReader(buf))
+	valDec := yaml.NewDecoder(bytes.NewReader(buf))
+	for index := 1; ; index++ {
+		var node yamlv3.Node
+		if err = dec.Decode(&node); err != nil {
+			if err == io.EOF {
+				err = nil
+			} else {
+				err = fmt.Errorf("第 %d 个文档: %s", index, err.Error())
+			}
+			return
+		}
+
+		// 两个解析器逐个文档同步前进
+		var unit Unit
+		valErr := valDec.Decode(&unit)
+
+		if len(node.Content) == 0 {
+			continue
+		}
+
+		doc := unitDocument{Index: index}
+		root := node.Content[0]
+		if root.Kind == yamlv3.ScalarNode && root.Tag == "!!null" {
+			continue
+		}
+		if root.Kind != yamlv3.MappingNode {
+			doc.Errs = append(doc.Errs, &SchemaError{Line: root.Line, Column: root.Column, Message: "单元必须是键值对"})
+		} else if doc.Errs = checkUnitNode(root); len(doc.Errs) == 0 {
+			if valErr != nil {
+				doc.Errs = append(doc.Errs, unitDecodeErrors(valErr)...)
+			} else {
+				doc.Unit = unit
 			}
-		} else {
-			units = append(units, unit)
 		}
+		normalizeUnit(&doc.Unit)
+		docs = append(docs, doc)
+	}
+}
+
+// normalizeUnit 清理字段中的空格，设置默认组名
+func normalizeUnit(unit *Unit) {
+	// 清理下空格
+	unit.Name = strings.TrimSpace(unit.Name)
+	unit.Kind = strings.TrimSpace(unit.Kind)
+	unit.Cron = strings.TrimSpace(unit.Cron)
+	unit.Dir = strings.TrimSpace(unit.Dir)
+	unit.Group = strings.TrimSpace(unit.Group)
+
+	// 默认组名
+	if unit.Group == "" {
+		unit.Group = DefaultGroup
+	}
+}
+
+// expandUnit 展开设置了 count 的单元
+func expandUnit(unit Unit) (units []Unit) {
+	// 重复型
+	if unit.Count > 0 {
+		for i := 0; i < unit.Count; i++ {
+			subUnit := unit
+			subUnit.Name = fmt.Sprintf("%s-%d", unit.Name, i+1)
+			subUnit.baseName = unit.Name
+			units = append(units, subUnit)
+		}
+	} else {
+		units = append(units, unit)
 	}
+	return
+}
+
+// checkUnitName 检查单元名称
+func checkUnitName(name string) error {
+	if name == "" {
+		return fmt.Errorf("缺少单元名称，检查 name 字段")
+	}
+	if !UnitNamePattern.MatchString(name) {
+		return fmt.Errorf("单元名称 %s 不符合规则，检查 name 字段", name)
+	}
+	return nil
+}
+
+// unitDecodeErrors 拆分 yaml 的类型错误，每个字段一个错误
+func unitDecodeErrors(err error) (errs []error) {
+	te, ok := err.(*yaml.TypeError)
+	if !ok {
+		return []error{err}
+	}
+	for _, msg := range te.Errors {
+		var line int
+		if _, err := fmt.Sscanf(msg, "line %d:", &line); err == nil {
+			msg = fmt.Sprintf("第 %d 行: %s", line, strings.TrimSpace(msg[strings.Index(msg, ":")+1:]))
+		}
+		errs = append(errs, errors.New(msg))
+	}
+	return
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/stretchr/testify/require"
+	"os"
 	"path/filepath"
 	"testing"
 )
@@ -12,3 +13,37 @@ func TestLoadDir(t *testing.T) {
 	require.Equal(t, "cron", units[4].Kind)
 	require.Equal(t, "@every 10s", units[4].Cron)
 }
+
+func TestLoadEnvMain(t *testing.T) {
+	defer os.Unsetenv("MINIT_MAIN")
+	defer os.Unsetenv("MINIT_MAIN_ONCE")
+	defer os.Unsetenv("MINIT_MAIN_CRITICAL")
+	_ = os.Setenv("MINIT_MAIN", "echo hello")
+	_ = os.Setenv("MINIT_MAIN_ONCE", "true")
+	unit, ok, err := LoadEnvMain()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "once", unit.Kind)
+	require.Equal(t, []string{"echo", "hello"}, unit.Command)
+	require.False(t, unit.Critical)
+
+	_ = os.Setenv("MINIT_MAIN_CRITICAL", "true")
+	unit, ok, err = LoadEnvMain()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.True(t, unit.Critical)
+
+	_ = os.Unsetenv("MINIT_MAIN_CRITICAL")
+	_ = os.Setenv("MINIT_MAIN_ONCE", "false")
+	unit, ok, err = LoadEnvMain()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "daemon", unit.Kind)
+	require.True(t, unit.Critical)
+
+	_ = os.Setenv("MINIT_MAIN_CRITICAL", "false")
+	unit, ok, err = LoadEnvMain()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.False(t, unit.Critical)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"github.com/guoyk93/minit/pkg/mlog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ByteSize 文件大小，支持 K, M, G 单位，按照 1024 计算，比如 64M, 512Ki, 1GB
+type ByteSize int64
+
+func parseByteSize(s string) (size ByteSize, err error) {
+	s = strings.TrimSpace(s)
+	num := strings.TrimRightFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	unit := strings.ToUpper(strings.TrimSpace(s[len(num):]))
+	unit = strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I")
+
+	var mul float64
+	switch unit {
+	case "":
+		mul = 1
+	case "K":
+		mul = 1024
+	case "M":
+		mul = 1024 * 1024
+	case "G":
+		mul = 1024 * 1024 * 1024
+	default:
+		err = fmt.Errorf("无效的文件大小: %s", s)
+		return
+	}
+
+	var val float64
+	if val, err = strconv.ParseFloat(num, 64); err != nil || val < 0 {
+		err = fmt.Errorf("无效的文件大小: %s", s)
+		return
+	}
+	size = ByteSize(val * mul)
+	return
+}
+
+func (b *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	var s string
+	if err = unmarshal(&s); err != nil {
+		return
+	}
+	*b, err = parseByteSize(s)
+	return
+}
+
+func (b *ByteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *ByteSize) Set(s string) (err error) {
+	*b, err = parseByteSize(s)
+	return
+}
+
+// LogOptions 单元的日志配置，未设置的字段使用全局默认值
+type LogOptions struct {
+	MaxSize        ByteSize      `yaml:"max_size"`        // 单个日志文件的最大大小，超过后归档
+	MaxFiles       int           `yaml:"max_files"`       // 保留的归档文件数量，-1 表示不限制
+	MaxAge         time.Duration `yaml:"max_age"`         // 归档文件的最长保留时间
+	Compress       *bool         `yaml:"compress"`        // 使用 gzip 压缩归档文件
+	Merge          *bool         `yaml:"merge"`           // 将标准输出和标准错误写入同一个文件
+	DisableFile    *bool         `yaml:"disable_file"`    // 不写入日志文件
+	DisableConsole *bool         `yaml:"disable_console"` // 不输出到控制台
+}
+
+// optLogDefaults 全局默认的日志配置，来自命令行参数和环境变量
+var optLogDefaults = mlog.LoggerOptions{
+	MaxFileSize: mlog.DefaultLogFileMaxSize,
+	MaxFiles:    mlog.DefaultLogFileMaxFiles,
+}
+
+// loadLogEnv 从环境变量载入全局默认的日志配置
+func loadLogEnv() (err error) {
+	if val := strings.TrimSpace(os.Getenv("MINIT_LOG_MAX_SIZE")); val != "" {
+		var size ByteSize
+		if size, err = parseByteSize(val); err != nil {
+			return fmt.Errorf("无效的环境变量 MINIT_LOG_MAX_SIZE=%s: %s", val, err.Error())
+		}
+		optLogDefaults.MaxFileSize = int64(size)
+	}
+	if val := strings.TrimSpace(os.Getenv("MINIT_LOG_MAX_FILES")); val != "" {
+		if optLogDefaults.MaxFiles, err = strconv.ParseInt(val, 10, 64); err != nil {
+			return fmt.Errorf("无效的环境变量 MINIT_LOG_MAX_FILES=%s: %s", val, err.Error())
+		}
+	}
+	if val := strings.TrimSpace(os.Getenv("MINIT_LOG_MAX_AGE")); val != "" {
+		if optLogDefaults.MaxAge, err = time.ParseDuration(val); err != nil {
+			return fmt.Errorf("无效的环境变量 MINIT_LOG_MAX_AGE=%s: %s", val, err.Error())
+		}
+	}
+	for key, val := range map[string]*bool{
+		"MINIT_LOG_COMPRESS":        &optLogDefaults.Compress,
+		"MINIT_LOG_MERGE":           &optLogDefaults.Merge,
+		"MINIT_LOG_DISABLE_FILE":    &optLogDefaults.DisableFile,
+		"MINIT_LOG_DISABLE_CONSOLE": &optLogDefaults.DisableConsole,
+	} {
+		if raw := strings.TrimSpace(os.Getenv(key)); raw != "" {
+			if *val, err = strconv.ParseBool(raw); err != nil {
+				return fmt.Errorf("无效的环境变量 %s=%s: %s", key, raw, err.Error())
+			}
+		}
+	}
+	return checkLoggerOptions(optLogDefaults)
+}
+
+// checkLoggerOptions 检查日志配置
+func checkLoggerOptions(opts mlog.LoggerOptions) error {
+	if opts.MaxFileSize < 0 {
+		return fmt.Errorf("文件大小不能为负数，检查 log.max_size 字段")
+	}
+	if opts.MaxAge < 0 {
+		return fmt.Errorf("时间不能为负数，检查 log.max_age 字段")
+	}
+	return nil
+}
+
+// loggerOptions 计算单元的日志配置，使用全局默认值填充未设置的字段
+func (o LogOptions) loggerOptions(defaults mlog.LoggerOptions) (opts mlog.LoggerOptions, err error) {
+	opts = defaults
+	if o.MaxSize != 0 {
+		opts.MaxFileSize = int64(o.MaxSize)
+	}
+	if o.MaxFiles != 0 {
+		opts.MaxFiles = int64(o.MaxFiles)
+	}
+	if o.MaxAge != 0 {
+		opts.MaxAge = o.MaxAge
+	}
+	if o.Compress != nil {
+		opts.Compress = *o.Compress
+	}
+	if o.Merge != nil {
+		opts.Merge = *o.Merge
+	}
+	if o.DisableFile != nil {
+		opts.DisableFile = *o.DisableFile
+	}
+	if o.DisableConsole != nil {
+		opts.DisableConsole = *o.DisableConsole
+	}
+	err = checkLoggerOptions(opts)
+	return
+}
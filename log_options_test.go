@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/guoyk93/minit/pkg/mlog"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for input, expected := range map[string]ByteSize{
+		"1024":  1024,
+		"512K":  512 * 1024,
+		"64Mi":  64 * 1024 * 1024,
+		"64MB":  64 * 1024 * 1024,
+		"1.5g":  1536 * 1024 * 1024,
+		"2 GiB": 2 * 1024 * 1024 * 1024,
+	} {
+		size, err := parseByteSize(input)
+		require.NoError(t, err, input)
+		require.Equal(t, expected, size, input)
+	}
+	for _, input := range []string{"", "M", "10T", "-1M", "abc"} {
+		_, err := parseByteSize(input)
+		require.Error(t, err, input)
+	}
+}
+
+func TestLogOptions(t *testing.T) {
+	var unit Unit
+	err := yaml.Unmarshal([]byte(`
+name: test
+kind: cron
+log:
+  max_size: 1M
+  max_files: -1
+  max_age: 24h
+  compress: false
+  disable_console: true
+`), &unit)
+	require.NoError(t, err)
+
+	opts, err := unit.Log.loggerOptions(mlog.LoggerOptions{
+		MaxFileSize: mlog.DefaultLogFileMaxSize,
+		MaxFiles:    mlog.DefaultLogFileMaxFiles,
+		Compress:    true,
+		Merge:       true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, mlog.LoggerOptions{
+		MaxFileSize:    1024 * 1024,
+		MaxFiles:       -1,
+		MaxAge:         24 * time.Hour,
+		Merge:          true,
+		DisableConsole: true,
+	}, opts)
+
+	_, err = LogOptions{MaxAge: -time.Hour}.loggerOptions(mlog.LoggerOptions{})
+	require.Error(t, err)
+}
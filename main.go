@@ -1,22 +1,26 @@
 package main
 
 import (
-	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/guoyk93/minit/pkg/mlog"
 	"os"
 	"os/signal"
 	"regexp"
-	"sync"
+	"strings"
 	"syscall"
 	"time"
 )
 
 var (
-	optUnitDir   string
-	optLogDir    string
-	optQuickExit bool
+	optUnitDir         string
+	optLogDir          string
+	optQuickExit       bool
+	optShutdownTimeout time.Duration
+	optControlSocket   string
+	optLogFormat       string
+	optWatchUnitDir    bool
 )
 
 var (
@@ -27,10 +31,32 @@ var (
 	log *mlog.Logger
 )
 
+// ExitCodeError 以指定的退出码退出
+type ExitCodeError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitCodeError) Error() string {
+	return e.Err.Error()
+}
+
 func exit(err *error) {
 	if *err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "%s [%s] 错误退出: %s\n", time.Now().Format(mlog.LoggerDateLayout), "minit", (*err).Error())
-		os.Exit(1)
+		// 日志已经创建时，按照指定的日志格式输出
+		if log != nil {
+			log.Errorf("错误退出: %s", (*err).Error())
+		} else {
+			_, _ = fmt.Fprintf(os.Stderr, "%s [%s] 错误退出: %s\n", time.Now().Format(mlog.LoggerDateLayout), "minit", (*err).Error())
+		}
+		code := 1
+		var ece *ExitCodeError
+		if errors.As(*err, &ece) {
+			code = ece.Code
+		}
+		os.Exit(code)
+	} else if log != nil {
+		log.Printf("正常退出")
 	} else {
 		_, _ = fmt.Fprintf(os.Stdout, "%s [%s] 正常退出\n", time.Now().Format(mlog.LoggerDateLayout), "minit")
 	}
@@ -44,12 +70,52 @@ func main() {
 	flag.StringVar(&optUnitDir, "unit-dir", "/etc/minit.d", "配置单元目录")
 	flag.StringVar(&optLogDir, "log-dir", "/var/log/minit", "日志目录")
 	flag.BoolVar(&optQuickExit, "quick-exit", false, "如果没有 L3 任务（守护进程，定时任务 等），则自动退出")
+	flag.DurationVar(&optShutdownTimeout, "shutdown-timeout", time.Second*25, "退出时等待所有单元停止的最长时间，超时后强制结束所有进程")
+	flag.StringVar(&optLogFormat, "log-format", mlog.FormatText, "日志格式，text 或者 json")
+	flag.Var((*ByteSize)(&optLogDefaults.MaxFileSize), "log-max-size", "单个日志文件的最大大小，支持 K, M, G 单位")
+	flag.Int64Var(&optLogDefaults.MaxFiles, "log-max-files", optLogDefaults.MaxFiles, "保留的日志归档文件数量，-1 表示不限制")
+	flag.DurationVar(&optLogDefaults.MaxAge, "log-max-age", 0, "日志归档文件的最长保留时间，默认不限制")
+	flag.BoolVar(&optLogDefaults.Compress, "log-compress", false, "使用 gzip 压缩日志归档文件")
+	flag.BoolVar(&optLogDefaults.Merge, "log-merge", false, "将标准输出和标准错误写入同一个日志文件")
+	flag.BoolVar(&optLogDefaults.DisableFile, "log-disable-file", false, "不写入日志文件")
+	flag.BoolVar(&optLogDefaults.DisableConsole, "log-disable-console", false, "不输出日志到控制台")
+	flag.BoolVar(&optWatchUnitDir, "watch-unit-dir", false, "监视配置单元目录，发生变化时重新载入单元")
+	flag.StringVar(&optControlSocket, "control-socket", DefaultControlSocket, "控制套接字路径，设置为空字符串以禁用控制接口")
 	flag.Parse()
 
 	// 环境变量
 	if os.Getenv("MINIT_QUICK_EXIT") == "true" {
 		optQuickExit = true
 	}
+	if os.Getenv("MINIT_WATCH_UNIT_DIR") == "true" {
+		optWatchUnitDir = true
+	}
+	if val := strings.TrimSpace(os.Getenv("MINIT_SHUTDOWN_TIMEOUT")); val != "" {
+		if optShutdownTimeout, err = time.ParseDuration(val); err != nil {
+			err = fmt.Errorf("无效的环境变量 MINIT_SHUTDOWN_TIMEOUT=%s: %s", val, err.Error())
+			return
+		}
+	}
+	if val := strings.TrimSpace(os.Getenv("MINIT_LOG_FORMAT")); val != "" {
+		optLogFormat = val
+	}
+	if err = loadLogEnv(); err != nil {
+		return
+	}
+	if optLogFormat != mlog.FormatText && optLogFormat != mlog.FormatJSON {
+		err = fmt.Errorf("未知的日志格式 %s，检查 --log-format 参数或者 MINIT_LOG_FORMAT 环境变量", optLogFormat)
+		return
+	}
+	if val, ok := os.LookupEnv("MINIT_CONTROL_SOCKET"); ok {
+		optControlSocket = strings.TrimSpace(val)
+	}
+
+	// 子命令，比如 minit validate, minit ctl restart <unit>，"--" 之后的参数总是作为主程序
+	dashed := flag.NArg() > 0 && len(os.Args) > flag.NArg() && os.Args[len(os.Args)-flag.NArg()-1] == "--"
+	if name, args, ok := findSubcommand(flag.Args(), dashed); ok {
+		runSubcommand(name, args)
+		return
+	}
 
 	// 确保配置单元目录
 	if err = os.MkdirAll(optUnitDir, 0755); err != nil {
@@ -61,7 +127,17 @@ func main() {
 		return
 	}
 
-	if log, err = mlog.NewLogger(optLogDir, "minit", "minit"); err != nil {
+	logOpts := optLogDefaults
+	logOpts.Dir = optLogDir
+	logOpts.Filename = "minit"
+	logOpts.Name = "minit"
+	logOpts.Format = optLogFormat
+	if log, err = mlog.NewLoggerWithOptions(logOpts); err != nil {
+		return
+	}
+
+	// 回收僵尸进程
+	if err = SetupReaper(); err != nil {
 		return
 	}
 
@@ -87,6 +163,118 @@ func main() {
 
 	// 载入单元
 	var units []Unit
+	if units, err = loadUnits(); err != nil {
+		return
+	}
+
+	// 创建控制器, L1 是 render (渲染配置文件), L2 是 once (一次性命令), L3 是 daemon, cron, timer 等
+	// 单元按照 after, wants, requires 字段以及级别构建的依赖关系启动
+	var m *Manager
+	if m, err = NewManager(units); err != nil {
+		return
+	}
+
+	// 注册信号
+	chSig := make(chan os.Signal, 1)
+	signal.Notify(chSig, syscall.SIGINT, syscall.SIGTERM)
+
+	// SIGHUP 以及单元目录的变化触发重新载入
+	chReload := make(chan os.Signal, 1)
+	signal.Notify(chReload, syscall.SIGHUP)
+
+	m.Start()
+
+	// 控制接口，启动失败不影响单元运行
+	if err := SetupControl(m); err != nil {
+		log.Errorf("%s", err.Error())
+	} else if optControlSocket != "" {
+		defer os.Remove(optControlSocket)
+	}
+
+	// 没有 L3 任务并且开启了快速退出时，所有单元结束后直接退出，重新载入的单元同样计算在内
+	var chQuickExit <-chan struct{}
+	if optQuickExit {
+		chQuickExit = m.Done()
+	}
+
+	var chWatch <-chan struct{}
+	if optWatchUnitDir {
+		if chWatch, err = WatchUnitDir(optUnitDir); err != nil {
+			return
+		}
+	}
+
+	// 在单独的 goroutine 中依次重新载入，避免阻塞信号处理，多次触发会合并
+	chReloadReq := make(chan struct{}, 1)
+	go func() {
+		for range chReloadReq {
+			reloadUnits(m)
+		}
+	}()
+	requestReload := func() {
+		select {
+		case chReloadReq <- struct{}{}:
+		default:
+		}
+	}
+
+loop:
+	for {
+		select {
+		case <-chQuickExit:
+			log.Printf("没有 L3 任务")
+			select {
+			case <-m.Fatal():
+				err = fatalExitError(m)
+			default:
+			}
+			return
+		case sig := <-chSig:
+			log.Printf("接收到信号: %s", sig.String())
+			break loop
+		case <-m.Fatal():
+			err = fatalExitError(m)
+			log.Printf("停止所有单元")
+			break loop
+		case sig := <-chReload:
+			log.Printf("接收到信号: %s，重新载入单元", sig.String())
+			requestReload()
+		case <-chWatch:
+			log.Printf("单元目录发生变化，重新载入单元")
+			requestReload()
+		}
+	}
+
+	// 按照依赖关系的相反顺序停止单元，各单元按照 stop_signal 和 stop_timeout 停止进程
+	chDone := m.Shutdown()
+
+	timer := time.NewTimer(optShutdownTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-chDone:
+	case <-timer.C:
+		log.Errorf("未能在 %s 内停止所有单元，强制结束所有进程", optShutdownTimeout.String())
+		notifyPIDs(syscall.SIGKILL)
+		// 给予控制器少量时间记录退出状态
+		select {
+		case <-chDone:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// fatalExitError 根据 Manager 记录的退出码，生成 minit 的退出错误
+func fatalExitError(m *Manager) error {
+	code, err := m.FatalExit()
+	if err == nil {
+		return nil
+	}
+	return &ExitCodeError{Code: code, Err: err}
+}
+
+// loadUnits 从单元目录、环境变量和命令参数载入单元，并检查单元命名
+func loadUnits() (units []Unit, err error) {
 	if units, err = LoadDir(optUnitDir); err != nil {
 		return
 	}
@@ -114,12 +302,7 @@ func main() {
 	// 检查单元命名
 	unitNames := map[string]bool{"minit": true}
 	for _, unit := range units {
-		if unit.Name == "" {
-			err = fmt.Errorf("缺少单元名称，检查 name 字段")
-			return
-		}
-		if !UnitNamePattern.MatchString(unit.Name) {
-			err = fmt.Errorf("单元名称 %s 不符合规则，检查 name 字段", unit.Name)
+		if err = checkUnitName(unit.Name); err != nil {
 			return
 		}
 		if unitNames[unit.Name] {
@@ -129,74 +312,16 @@ func main() {
 		unitNames[unit.Name] = true
 		log.Printf("载入单元 %s/%s", unit.Kind, unit.Name)
 	}
+	return
+}
 
-	// 控制器组, L1 是 render (渲染配置文件), L2 是 once (一次性命令), L3 是 daemon 和 cron
-	runners := map[RunnerLevel][]Runner{}
-
-	// 创建控制器
-	for _, unit := range units {
-		fac := RunnerFactories[unit.Kind]
-		if fac == nil {
-			err = fmt.Errorf("单元 %s 类型 %s 未知，检查 kind 字段", unit.Name, unit.Kind)
-			return
-		}
-
-		var logger *mlog.Logger
-		if logger, err = mlog.NewLogger(optLogDir, unit.CanonicalName(), unit.Name); err != nil {
-			err = fmt.Errorf("无法为 %s 创建日志: %s", unit.Name, err.Error())
-			return
-		}
-
-		var runner Runner
-		if runner, err = fac.Create(unit, logger); err != nil {
-			err = fmt.Errorf("无法为 %s 创建控制器: %s", unit.Name, err.Error())
-			return
-		}
-
-		runners[fac.Level] = append(runners[fac.Level], runner)
-	}
-
-	// 运行 L1 控制器
-	for _, runner := range runners[RunnerL1] {
-		runner.Run(context.Background())
-	}
-	// 运行 L2 控制器
-	for _, runner := range runners[RunnerL2] {
-		runner.Run(context.Background())
+// reloadUnits 重新载入单元，新的配置无效时保持现有的单元
+func reloadUnits(m *Manager) {
+	units, err := loadUnits()
+	if err == nil {
+		err = m.Reload(units)
 	}
-
-	if len(runners[RunnerL3]) == 0 && optQuickExit {
-		log.Printf("没有 L3 任务")
-		return
+	if err != nil {
+		log.Errorf("重新载入失败，保持现有配置: %s", err.Error())
 	}
-
-	// 运行 L3 控制器
-	ctx, cancel := context.WithCancel(context.Background())
-	wg := &sync.WaitGroup{}
-
-	for _, runner := range runners[RunnerL3] {
-		wg.Add(1)
-		go func(runner Runner) {
-			runner.Run(ctx)
-			wg.Done()
-		}(runner)
-	}
-
-	log.Printf("启动完毕")
-
-	// 等待信号并退出
-	chSig := make(chan os.Signal, 1)
-	signal.Notify(chSig, syscall.SIGINT, syscall.SIGTERM)
-	sig := <-chSig
-	log.Printf("接收到信号: %s", sig.String())
-
-	// 关闭主环境
-	cancel()
-
-	// 延迟 3 秒播发信号
-	time.Sleep(time.Second * 3)
-	notifyPIDs(sig)
-
-	// 等待控制器退出
-	wg.Wait()
 }
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/guoyk93/minit/pkg/mlog"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+type managedDependency struct {
+	unit *managedUnit
+	kind string
+}
+
+type managedUnit struct {
+	Unit
+	level  RunnerLevel
+	runner Runner
+	logger *mlog.Logger
+	status *UnitStatus
+
+	deps       []managedDependency
+	dependents []*managedUnit
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+	// stopOnce 单元可能同时被 Reload 和 Shutdown 停止，保证 stopped 只关闭一次
+	stopOnce *sync.Once
+}
+
+var (
+	ErrUnitNotFound   = errors.New("单元不存在")
+	ErrUnitNotRunning = errors.New("单元没有运行")
+	ErrUnitRunning    = errors.New("单元正在运行")
+)
+
+// Manager 按照依赖关系启动单元，并按照相反的顺序停止单元
+type Manager struct {
+	units []*managedUnit
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	done         chan struct{}
+	reloaded     chan struct{}
+	stopped      chan struct{}
+	shutdownOnce *sync.Once
+
+	fatal     chan struct{}
+	fatalOnce *sync.Once
+	fatalCode int
+	fatalErr  error
+
+	// l 保护 managedUnit 的 ctx 和 cancel，ctl 保证控制操作依次执行
+	l   sync.Locker
+	ctl sync.Locker
+}
+
+// newManagedUnit 为单元创建日志和控制器，logger 不为空时使用已有的日志
+func newManagedUnit(unit Unit, logger *mlog.Logger) (mu *managedUnit, err error) {
+	fac := RunnerFactories[unit.Kind]
+	if fac == nil {
+		err = fmt.Errorf("单元 %s 类型 %s 未知，检查 kind 字段", unit.Name, unit.Kind)
+		return
+	}
+
+	if logger == nil {
+		var logOpts mlog.LoggerOptions
+		if logOpts, err = unit.Log.loggerOptions(optLogDefaults); err != nil {
+			err = fmt.Errorf("无法为 %s 创建日志: %s", unit.Name, err.Error())
+			return
+		}
+		logOpts.Dir = optLogDir
+		logOpts.Filename = unit.Name
+		logOpts.Name = unit.Name
+		logOpts.Kind = unit.Kind
+		logOpts.Format = optLogFormat
+
+		if logger, err = mlog.NewLoggerWithOptions(logOpts); err != nil {
+			err = fmt.Errorf("无法为 %s 创建日志: %s", unit.Name, err.Error())
+			return
+		}
+	}
+
+	var runner Runner
+	if runner, err = fac.Create(unit, logger); err != nil {
+		err = fmt.Errorf("无法为 %s 创建控制器: %s", unit.Name, err.Error())
+		return
+	}
+
+	mu = &managedUnit{
+		Unit:     unit,
+		level:    fac.Level,
+		runner:   runner,
+		logger:   logger,
+		status:   NewUnitStatus(),
+		stopped:  make(chan struct{}),
+		stopOnce: &sync.Once{},
+	}
+	mu.ctx, mu.cancel = context.WithCancel(context.Background())
+	return
+}
+
+// resolveDependencies 计算单元之间的依赖关系
+func resolveDependencies(mus []*managedUnit) (deps [][]UnitDependency, err error) {
+	units := make([]Unit, 0, len(mus))
+	levels := make([]RunnerLevel, 0, len(mus))
+	for _, mu := range mus {
+		units = append(units, mu.Unit)
+		levels = append(levels, mu.level)
+	}
+
+	var warnings []string
+	if deps, warnings, err = BuildDependencies(units, levels); err != nil {
+		return
+	}
+	for _, warning := range warnings {
+		log.Printf("%s", warning)
+	}
+	return
+}
+
+// linkDependencies 按照 resolveDependencies 的结果设置单元之间的依赖关系，覆盖已有的依赖关系
+func linkDependencies(mus []*managedUnit, deps [][]UnitDependency) {
+	for _, mu := range mus {
+		mu.deps, mu.dependents = nil, nil
+	}
+	for i, mu := range mus {
+		for _, dep := range deps[i] {
+			mu.deps = append(mu.deps, managedDependency{unit: mus[dep.Index], kind: dep.Kind})
+			mus[dep.Index].dependents = append(mus[dep.Index].dependents, mu)
+		}
+	}
+}
+
+func NewManager(units []Unit) (m *Manager, err error) {
+	m = &Manager{
+		done:         make(chan struct{}),
+		reloaded:     make(chan struct{}),
+		stopped:      make(chan struct{}),
+		shutdownOnce: &sync.Once{},
+		fatal:        make(chan struct{}),
+		fatalOnce:    &sync.Once{},
+		l:            &sync.Mutex{},
+		ctl:          &sync.Mutex{},
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+
+	// 创建控制器
+	for _, unit := range units {
+		var mu *managedUnit
+		if mu, err = newManagedUnit(unit, nil); err != nil {
+			return
+		}
+		m.units = append(m.units, mu)
+	}
+
+	// 构建依赖关系
+	var deps [][]UnitDependency
+	if deps, err = resolveDependencies(m.units); err != nil {
+		return
+	}
+	linkDependencies(m.units, deps)
+	return
+}
+
+// managedUnits 返回当前的单元列表，重新载入后单元列表会被替换
+func (m *Manager) managedUnits() []*managedUnit {
+	m.l.Lock()
+	defer m.l.Unlock()
+	return m.units
+}
+
+// Start 启动所有单元，每个单元在依赖单元就绪或者结束后启动
+func (m *Manager) Start() {
+	units := m.managedUnits()
+
+	for _, mu := range units {
+		go m.run(mu, mu.ctx, true)
+	}
+
+	go func() {
+		var failed []string
+		for _, mu := range units {
+			select {
+			case <-mu.status.Ready():
+			case <-mu.status.Done():
+				if mu.status.Err() != nil {
+					failed = append(failed, mu.Name)
+				}
+			case <-m.ctx.Done():
+				return
+			}
+		}
+		if len(failed) > 0 {
+			log.Errorf("启动完毕，以下单元失败: %s", strings.Join(failed, ", "))
+		} else {
+			log.Printf("启动完毕，所有单元已经就绪")
+		}
+	}()
+
+	go m.watchDone()
+}
+
+// watchDone 等待当前的单元全部结束，重新载入后按照新的单元列表重新等待，存在 L3 单元时不会关闭 done
+func (m *Manager) watchDone() {
+	for {
+		m.l.Lock()
+		units, reloaded := m.units, m.reloaded
+		m.l.Unlock()
+
+		idle := true
+		for _, mu := range units {
+			if mu.level == RunnerL3 {
+				idle = false
+				break
+			}
+		}
+
+		if idle {
+		wait:
+			for _, mu := range units {
+				select {
+				case <-mu.status.Done():
+				case <-reloaded:
+					idle = false
+					break wait
+				}
+			}
+		} else {
+			<-reloaded
+		}
+
+		if idle {
+			close(m.done)
+			return
+		}
+	}
+}
+
+// run 等待依赖单元后运行单元，abort 的含义与 execute 相同
+func (m *Manager) run(mu *managedUnit, ctx context.Context, abort bool) {
+	m.l.Lock()
+	deps := mu.deps
+	m.l.Unlock()
+
+	// 等待依赖单元
+	for _, dep := range deps {
+		select {
+		case <-dep.unit.status.Ready():
+		case <-dep.unit.status.Done():
+		default:
+			if dep.kind != DependLevel {
+				mu.logger.Printf("等待单元 %s", dep.unit.Name)
+			}
+			select {
+			case <-dep.unit.status.Ready():
+			case <-dep.unit.status.Done():
+			case <-ctx.Done():
+				mu.status.finish(UnitStopped)
+				return
+			case <-m.ctx.Done():
+				mu.status.finish(UnitStopped)
+				return
+			}
+		}
+
+		if err := dep.unit.status.Err(); err != nil {
+			switch dep.kind {
+			case DependRequires:
+				mu.logger.Errorf("依赖单元 %s 失败，不再启动", dep.unit.Name)
+				mu.status.SetFailed(fmt.Errorf("依赖单元 %s 失败", dep.unit.Name))
+				mu.status.finish(UnitSkipped)
+				m.checkCritical(mu)
+				return
+			case DependWants:
+				mu.logger.Printf("依赖单元 %s 失败，继续启动", dep.unit.Name)
+			}
+		}
+	}
+
+	if ctx.Err() != nil || m.ctx.Err() != nil {
+		mu.status.finish(UnitStopped)
+		return
+	}
+
+	m.execute(mu, ctx, abort)
+}
+
+// setFatal 通知 minit 停止所有单元，并以指定的退出码退出，只有第一次调用有效
+func (m *Manager) setFatal(code int, err error) {
+	m.fatalOnce.Do(func() {
+		m.fatalCode = code
+		m.fatalErr = err
+		close(m.fatal)
+	})
+}
+
+// Fatal 关键单元结束，或者需要中止启动时关闭
+func (m *Manager) Fatal() <-chan struct{} {
+	return m.fatal
+}
+
+// FatalExit 返回 minit 的退出码和错误，关键单元正常结束时，返回 0 和 nil，只能在 Fatal 关闭后调用
+func (m *Manager) FatalExit() (code int, err error) {
+	return m.fatalCode, m.fatalErr
+}
+
+// checkCritical 关键单元结束、失败或者跳过时，通知 minit 退出，手动停止和 minit 退出造成的停止除外
+//
+// once 类型的关键单元只在失败时通知 minit 退出，正常结束后其他单元继续运行
+func (m *Manager) checkCritical(mu *managedUnit) {
+	if !mu.Critical || mu.status.Phase() == UnitStopped {
+		return
+	}
+	if err := mu.status.Err(); err != nil {
+		m.setFatal(exitCodeOf(err), fmt.Errorf("关键单元 %s 失败: %s", mu.Name, err.Error()))
+	} else if mu.Kind == "once" && mu.status.Phase() == UnitExited {
+		log.Printf("关键单元 %s 已经完成", mu.Name)
+	} else {
+		log.Printf("关键单元 %s 已经结束", mu.Name)
+		m.setFatal(0, nil)
+	}
+}
+
+// checkAbort once 单元失败，并且 on_failure 为 abort 或者 retry 时，不再启动其他单元，通知 minit 退出
+func (m *Manager) checkAbort(mu *managedUnit) {
+	if mu.Kind != "once" || (mu.OnFailure != OnFailureAbort && mu.OnFailure != OnFailureRetry) {
+		return
+	}
+	err := mu.status.Err()
+	if err == nil {
+		return
+	}
+	log.Errorf("单元 %s 失败，中止启动", mu.Name)
+	m.l.Lock()
+	m.cancel()
+	m.l.Unlock()
+	m.setFatal(exitCodeOf(err), fmt.Errorf("单元 %s 失败，中止启动: %s", mu.Name, err.Error()))
+}
+
+// execute 运行单元，abort 为 true 时，按照 on_failure 字段中止启动，手动启动的单元不会中止启动
+func (m *Manager) execute(mu *managedUnit, ctx context.Context, abort bool) {
+	mu.status.setPhase(UnitRunning)
+	mu.runner.Run(ctx, mu.status)
+
+	// 在标记单元结束之前中止，避免依赖此单元的单元启动
+	if abort && ctx.Err() == nil {
+		m.checkAbort(mu)
+	}
+
+	if ctx.Err() != nil {
+		mu.status.finish(UnitStopped)
+	} else {
+		mu.status.finish(UnitExited)
+	}
+	m.checkCritical(mu)
+}
+
+// Done 没有 L3 单元并且所有单元结束时关闭，重新载入后按照新的单元列表计算
+func (m *Manager) Done() <-chan struct{} {
+	return m.done
+}
+
+// stopUnits 按照依赖关系的相反顺序停止指定的单元，不在 mus 中的依赖此单元的单元不受影响，全部停止后，返回的 chan 关闭
+func (m *Manager) stopUnits(mus []*managedUnit) <-chan struct{} {
+	stopping := map[*managedUnit]bool{}
+	for _, mu := range mus {
+		stopping[mu] = true
+	}
+
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	for _, mu := range mus {
+		var dependents []*managedUnit
+		for _, dependent := range mu.dependents {
+			if stopping[dependent] {
+				dependents = append(dependents, dependent)
+			}
+		}
+		cancel := mu.cancel
+
+		go func(mu *managedUnit) {
+			// 等待依赖此单元的单元先停止
+			for _, dependent := range dependents {
+				<-dependent.stopped
+			}
+			cancel()
+			<-mu.status.Done()
+			mu.stopOnce.Do(func() {
+				close(mu.stopped)
+			})
+		}(mu)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		for _, mu := range mus {
+			<-mu.stopped
+		}
+		close(stopped)
+	}()
+	return stopped
+}
+
+// Shutdown 按照依赖关系的相反顺序停止所有单元，全部停止后，返回的 chan 关闭
+func (m *Manager) Shutdown() <-chan struct{} {
+	m.shutdownOnce.Do(func() {
+		m.l.Lock()
+		m.cancel()
+		units := m.units
+		m.l.Unlock()
+
+		go func() {
+			<-m.stopUnits(units)
+			close(m.stopped)
+		}()
+	})
+	return m.stopped
+}
+
+func (m *Manager) findUnit(name string) (*managedUnit, error) {
+	for _, mu := range m.managedUnits() {
+		if mu.Name == name {
+			return mu, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnitNotFound, name)
+}
+
+// Status 返回所有单元的状态
+func (m *Manager) Status() (out []UnitStatusSnapshot) {
+	for _, mu := range m.managedUnits() {
+		ss := mu.status.snapshot(mu.Unit)
+		if hr, ok := mu.runner.(HistoryRunner); ok {
+			if records := hr.History(); len(records) > 0 {
+				ss.LastRun = &records[len(records)-1]
+			}
+		}
+		out = append(out, ss)
+	}
+	return
+}
+
+// UnitHistory 返回单元最近的执行记录
+func (m *Manager) UnitHistory(name string) (records []RunRecord, err error) {
+	var mu *managedUnit
+	if mu, err = m.findUnit(name); err != nil {
+		return
+	}
+	hr, ok := mu.runner.(HistoryRunner)
+	if !ok {
+		err = fmt.Errorf("单元 %s 类型 %s 没有执行记录", name, mu.Kind)
+		return
+	}
+	records = hr.History()
+	return
+}
+
+// UnitLogger 返回单元的日志，名称 minit 对应 minit 自身的日志
+func (m *Manager) UnitLogger(name string) (*mlog.Logger, error) {
+	if name == "minit" {
+		return log, nil
+	}
+	mu, err := m.findUnit(name)
+	if err != nil {
+		return nil, err
+	}
+	return mu.logger, nil
+}
+
+// StartUnit 启动已经结束的单元，不再等待依赖单元
+func (m *Manager) StartUnit(name string) (err error) {
+	m.ctl.Lock()
+	defer m.ctl.Unlock()
+
+	return m.startUnit(name)
+}
+
+func (m *Manager) startUnit(name string) (err error) {
+	var mu *managedUnit
+	if mu, err = m.findUnit(name); err != nil {
+		return
+	}
+
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	if m.ctx.Err() != nil {
+		return errors.New("minit 正在退出")
+	}
+
+	select {
+	case <-mu.status.Done():
+	default:
+		return fmt.Errorf("%w: %s", ErrUnitRunning, name)
+	}
+
+	mu.logger.Printf("手动启动单元")
+	mu.status.reset()
+	mu.ctx, mu.cancel = context.WithCancel(context.Background())
+	go m.execute(mu, mu.ctx, false)
+	return
+}
+
+// StopUnit 停止单元，等待单元结束后返回，依赖此单元的单元不受影响
+func (m *Manager) StopUnit(name string) (err error) {
+	m.ctl.Lock()
+	defer m.ctl.Unlock()
+
+	return m.stopUnit(name)
+}
+
+func (m *Manager) stopUnit(name string) (err error) {
+	var mu *managedUnit
+	if mu, err = m.findUnit(name); err != nil {
+		return
+	}
+
+	m.l.Lock()
+	cancel := mu.cancel
+	done := mu.status.Done()
+	m.l.Unlock()
+
+	select {
+	case <-done:
+		return fmt.Errorf("%w: %s", ErrUnitNotRunning, name)
+	default:
+	}
+
+	mu.logger.Printf("手动停止单元")
+	cancel()
+	<-done
+	return
+}
+
+// RestartUnit 重启单元，单元已经结束时直接启动
+func (m *Manager) RestartUnit(name string) (err error) {
+	m.ctl.Lock()
+	defer m.ctl.Unlock()
+
+	if err = m.stopUnit(name); err != nil && !errors.Is(err, ErrUnitNotRunning) {
+		return
+	}
+	return m.startUnit(name)
+}
+
+// TriggerUnit 手动触发单元，比如立即执行一次定时任务
+func (m *Manager) TriggerUnit(name string) (err error) {
+	var mu *managedUnit
+	if mu, err = m.findUnit(name); err != nil {
+		return
+	}
+	tr, ok := mu.runner.(TriggerRunner)
+	if !ok {
+		return fmt.Errorf("单元 %s 类型 %s 不支持手动触发", name, mu.Kind)
+	}
+	if phase := mu.status.Phase(); phase != UnitRunning && phase != UnitReady {
+		return fmt.Errorf("%w: %s", ErrUnitNotRunning, name)
+	}
+	if err = tr.Trigger(); err != nil {
+		return
+	}
+	mu.logger.Printf("手动触发单元")
+	return
+}
+
+// Reload 按照新的单元列表重新载入，启动新增的单元，停止删除的单元，重启配置变化的单元，其他单元保持运行
+//
+// 新的配置无效时返回错误，保持现有的单元不变
+func (m *Manager) Reload(units []Unit) (err error) {
+	m.ctl.Lock()
+	defer m.ctl.Unlock()
+
+	current := m.managedUnits()
+	currentByName := map[string]*managedUnit{}
+	for _, mu := range current {
+		currentByName[mu.Name] = mu
+	}
+
+	var (
+		next     []*managedUnit
+		created  []*managedUnit
+		stopping []*managedUnit
+		names    = map[string]bool{}
+		reused   = map[*mlog.Logger]bool{}
+
+		added, changed, removed []string
+	)
+
+	// 出错时关闭新创建的日志
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, mu := range created {
+			if !reused[mu.logger] {
+				_ = mu.logger.Close()
+			}
+		}
+	}()
+
+	for _, unit := range units {
+		names[unit.Name] = true
+
+		old := currentByName[unit.Name]
+		if old != nil && reflect.DeepEqual(old.Unit, unit) {
+			next = append(next, old)
+			continue
+		}
+
+		// 类型和日志配置没有变化时，继续使用已有的日志，日志中包含单元类型
+		var logger *mlog.Logger
+		if old != nil && old.Kind == unit.Kind && reflect.DeepEqual(old.Log, unit.Log) {
+			logger = old.logger
+			reused[logger] = true
+		}
+
+		var mu *managedUnit
+		if mu, err = newManagedUnit(unit, logger); err != nil {
+			return
+		}
+		next = append(next, mu)
+		created = append(created, mu)
+
+		if old != nil {
+			changed = append(changed, unit.Name)
+			stopping = append(stopping, old)
+		} else {
+			added = append(added, unit.Name)
+		}
+	}
+	for _, mu := range current {
+		if !names[mu.Name] {
+			removed = append(removed, mu.Name)
+			stopping = append(stopping, mu)
+		}
+	}
+
+	if len(created) == 0 && len(stopping) == 0 {
+		log.Printf("重新载入单元，没有变化")
+		return
+	}
+
+	var deps [][]UnitDependency
+	if deps, err = resolveDependencies(next); err != nil {
+		return
+	}
+
+	if m.ctx.Err() != nil {
+		return errors.New("minit 正在退出")
+	}
+
+	log.Printf(
+		"重新载入单元，新增: [%s]，变更: [%s]，删除: [%s]",
+		strings.Join(added, ", "),
+		strings.Join(changed, ", "),
+		strings.Join(removed, ", "),
+	)
+
+	// 按照依赖关系的相反顺序，停止删除和变更的单元
+	for _, mu := range stopping {
+		mu.logger.Printf("重新载入，停止单元")
+	}
+	<-m.stopUnits(stopping)
+	for _, mu := range stopping {
+		if !reused[mu.logger] {
+			_ = mu.logger.Close()
+		}
+	}
+
+	m.l.Lock()
+	if m.ctx.Err() != nil {
+		m.l.Unlock()
+		return errors.New("minit 正在退出")
+	}
+	linkDependencies(next, deps)
+	m.units = next
+	close(m.reloaded)
+	m.reloaded = make(chan struct{})
+	m.l.Unlock()
+
+	// 启动新增和变更的单元，依赖单元已经就绪或者结束时立即启动，失败时不会中止启动
+	for _, mu := range created {
+		go m.run(mu, mu.ctx, false)
+	}
+	return
+}
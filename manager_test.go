@@ -0,0 +1,201 @@
+package main
+
+import (
+	"github.com/guoyk93/minit/pkg/mlog"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestManagerReload(t *testing.T) {
+	logger, dir := newTestLogger(t)
+	defer func(v string) { optLogDir = v }(optLogDir)
+	optLogDir = dir
+	defer func(v *mlog.Logger) { log = v }(log)
+	log = logger
+
+	daemon := func(name string, command ...string) Unit {
+		return Unit{
+			Name:           name,
+			Kind:           "daemon",
+			Group:          DefaultGroup,
+			ExecuteOptions: ExecuteOptions{Command: append([]string{"sleep"}, command...)},
+		}
+	}
+	pids := func(m *Manager) map[string]int {
+		out := map[string]int{}
+		for _, s := range m.Status() {
+			out[s.Name] = s.PID
+		}
+		return out
+	}
+	waitReady := func(m *Manager) {
+		for _, mu := range m.managedUnits() {
+			select {
+			case <-mu.status.Ready():
+			case <-time.After(time.Second * 5):
+				t.Fatalf("unit %s not ready", mu.Name)
+			}
+		}
+	}
+
+	m, err := NewManager([]Unit{daemon("keep", "60"), daemon("change", "60"), daemon("remove", "60")})
+	require.NoError(t, err)
+	m.Start()
+	defer func() { <-m.Shutdown() }()
+	waitReady(m)
+	before := pids(m)
+
+	// 无效的配置，保持现有的单元
+	err = m.Reload([]Unit{daemon("keep", "60"), {Name: "bad", Kind: "bogus"}})
+	require.Error(t, err)
+	require.Equal(t, before, pids(m))
+
+	err = m.Reload([]Unit{daemon("keep", "60"), daemon("change", "61"), daemon("added", "60")})
+	require.NoError(t, err)
+	waitReady(m)
+	after := pids(m)
+
+	require.Len(t, after, 3)
+	require.Equal(t, before["keep"], after["keep"])
+	require.NotEqual(t, before["change"], after["change"])
+	require.NotZero(t, after["change"])
+	require.NotZero(t, after["added"])
+	_, ok := after["remove"]
+	require.False(t, ok)
+}
+
+func TestManagerReloadThenShutdown(t *testing.T) {
+	logger, dir := newTestLogger(t)
+	defer func(v string) { optLogDir = v }(optLogDir)
+	optLogDir = dir
+	defer func(v *mlog.Logger) { log = v }(log)
+	log = logger
+
+	// 忽略 SIGTERM，停止时需要等待 stop_timeout
+	m, err := NewManager([]Unit{{
+		Name:  "stubborn",
+		Kind:  "daemon",
+		Group: DefaultGroup,
+		ExecuteOptions: ExecuteOptions{
+			Command:     []string{"sh", "-c", "trap '' TERM; sleep 60"},
+			StopTimeout: time.Second,
+		},
+	}})
+	require.NoError(t, err)
+	m.Start()
+	for _, mu := range m.managedUnits() {
+		<-mu.status.Ready()
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	reloaded := make(chan error, 1)
+	go func() {
+		reloaded <- m.Reload(nil)
+	}()
+	time.Sleep(time.Millisecond * 200)
+
+	// Reload 仍在停止单元时退出
+	select {
+	case <-m.Shutdown():
+	case <-time.After(time.Second * 10):
+		t.Fatal("shutdown timeout")
+	}
+	// 退出期间 Reload 可能返回错误，只需要确认没有 panic
+	<-reloaded
+}
+
+func TestManagerCriticalOnce(t *testing.T) {
+	logger, dir := newTestLogger(t)
+	defer func(v string) { optLogDir = v }(optLogDir)
+	optLogDir = dir
+	defer func(v *mlog.Logger) { log = v }(log)
+	log = logger
+
+	m, err := NewManager([]Unit{
+		{Name: "init", Kind: "once", Group: DefaultGroup, Critical: true, ExecuteOptions: ExecuteOptions{Command: []string{"true"}}},
+		{Name: "app", Kind: "daemon", Group: DefaultGroup, ExecuteOptions: ExecuteOptions{Command: []string{"sleep", "60"}}},
+	})
+	require.NoError(t, err)
+	m.Start()
+	defer func() { <-m.Shutdown() }()
+
+	// 关键的 once 单元正常结束，不会导致 minit 退出
+	for _, mu := range m.managedUnits() {
+		select {
+		case <-mu.status.Ready():
+		case <-mu.status.Done():
+		case <-time.After(time.Second * 5):
+			t.Fatalf("unit %s not ready", mu.Name)
+		}
+	}
+	select {
+	case <-m.Fatal():
+		t.Fatal("critical once unit exited successfully but minit is stopping")
+	case <-time.After(time.Millisecond * 200):
+	}
+}
+
+func TestManagerStopCriticalOnce(t *testing.T) {
+	logger, dir := newTestLogger(t)
+	defer func(v string) { optLogDir = v }(optLogDir)
+	optLogDir = dir
+	defer func(v *mlog.Logger) { log = v }(log)
+	log = logger
+
+	m, err := NewManager([]Unit{
+		{Name: "job", Kind: "once", Group: DefaultGroup, Critical: true, ExecuteOptions: ExecuteOptions{Command: []string{"sleep", "60"}}},
+	})
+	require.NoError(t, err)
+	m.Start()
+	defer func() { <-m.Shutdown() }()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for m.Status()[0].PID == 0 {
+		require.True(t, time.Now().Before(deadline), "unit not started")
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// 手动停止关键单元，不会导致 minit 退出
+	require.NoError(t, m.StopUnit("job"))
+	require.Equal(t, UnitStopped, m.Status()[0].Phase)
+	select {
+	case <-m.Fatal():
+		t.Fatal("critical once unit was stopped manually but minit is stopping")
+	case <-time.After(time.Millisecond * 200):
+	}
+}
+
+func TestManagerReloadDone(t *testing.T) {
+	logger, dir := newTestLogger(t)
+	defer func(v string) { optLogDir = v }(optLogDir)
+	optLogDir = dir
+	defer func(v *mlog.Logger) { log = v }(log)
+	log = logger
+
+	m, err := NewManager([]Unit{
+		{Name: "app", Kind: "daemon", Group: DefaultGroup, ExecuteOptions: ExecuteOptions{Command: []string{"sleep", "60"}}},
+	})
+	require.NoError(t, err)
+	m.Start()
+	defer func() { <-m.Shutdown() }()
+	before := m.managedUnits()[0].logger
+
+	// 存在 L3 单元时不会关闭
+	select {
+	case <-m.Done():
+		t.Fatal("done closed while a daemon is running")
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	// 单元类型变化时，重新创建日志，所有单元结束后关闭
+	require.NoError(t, m.Reload([]Unit{
+		{Name: "app", Kind: "once", Group: DefaultGroup, ExecuteOptions: ExecuteOptions{Command: []string{"true"}}},
+	}))
+	require.True(t, before != m.managedUnits()[0].logger)
+	select {
+	case <-m.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("done not closed after reload")
+	}
+}
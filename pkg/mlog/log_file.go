@@ -1,16 +1,46 @@
 package mlog
 
 import (
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
+	"time"
 )
 
+const (
+	DefaultLogFileMaxSize  = 64 * 1024 * 1024
+	DefaultLogFileMaxFiles = 3
+
+	// logFileArchiveQueue 等待压缩的归档文件队列长度，队列满时写入会等待
+	logFileArchiveQueue = 16
+)
+
+var (
+	ErrLogFileClosed = errors.New("日志文件已经关闭")
+)
+
+// LogFileOptions 日志文件选项
+type LogFileOptions struct {
+	// MaxSize 单个文件的最大大小，超过后进行归档
+	MaxSize int64
+	// MaxFiles 保留的归档文件数量，小于等于 0 时不限制
+	MaxFiles int64
+	// MaxAge 归档文件的最长保留时间，为 0 时不限制
+	MaxAge time.Duration
+	// Compress 使用 gzip 压缩归档文件
+	Compress bool
+}
+
+// LogFile 按照大小自动归档的日志文件，可以在多个 goroutine 中同时写入
+//
+// 写入和归档都在 l 的保护下进行，归档文件的压缩和过期清理在后台 goroutine 中进行
 type LogFile struct {
 	name string
 	dir  string
@@ -18,8 +48,17 @@ type LogFile struct {
 	currentFile *os.File
 	currentSize int64
 
+	// lastID 最近一次归档使用的编号，打开文件时从目录中计算，之后只在内存中维护
+	lastID int64
+
 	maxSize  int64
 	maxCount int64
+	maxAge   time.Duration
+	compress bool
+
+	archives chan string
+	done     chan struct{}
+	closed   bool
 
 	l sync.Locker
 }
@@ -32,29 +71,128 @@ func (l *LogFile) archiveFileName(id int64) string {
 	return filepath.Join(l.dir, fmt.Sprintf("%s.%d.log", l.name, id))
 }
 
-func (l *LogFile) nextArchiveId() (id int64, err error) {
+// archiveID 从文件名中解析归档编号，包括压缩后的归档文件
+func (l *LogFile) archiveID(name string) (id int64, ok bool) {
+	if !strings.HasPrefix(name, l.name+".") {
+		return
+	}
+	idStr := strings.TrimPrefix(name, l.name+".")
+	if strings.HasSuffix(idStr, ".log.gz") {
+		idStr = strings.TrimSuffix(idStr, ".log.gz")
+	} else if strings.HasSuffix(idStr, ".log") {
+		idStr = strings.TrimSuffix(idStr, ".log")
+	} else {
+		return
+	}
+	var err error
+	if id, err = strconv.ParseInt(idStr, 10, 64); err != nil || id <= 0 {
+		return
+	}
+	ok = true
+	return
+}
+
+// scanArchives 扫描已有的归档文件，计算最近一次归档使用的编号，返回尚未压缩的归档文件
+//
+// 设置了 maxCount 时，编号会循环使用，因此以修改时间最新的归档文件为准
+func (l *LogFile) scanArchives() (uncompressed []string, err error) {
 	var fis []os.FileInfo
 	if fis, err = ioutil.ReadDir(l.dir); err != nil {
 		return
 	}
 
+	var latest time.Time
 	for _, fi := range fis {
-		if strings.HasPrefix(fi.Name(), l.name+".") &&
-			strings.HasSuffix(fi.Name(), ".log") {
-			idStr := strings.TrimSuffix(strings.TrimPrefix(fi.Name(), l.name+"."), ".log")
-			nid, _ := strconv.ParseInt(idStr, 10, 64)
-			if nid > id {
-				id = nid
-			}
+		id, ok := l.archiveID(fi.Name())
+		if !ok {
+			continue
+		}
+		if !strings.HasSuffix(fi.Name(), ".gz") {
+			uncompressed = append(uncompressed, filepath.Join(l.dir, fi.Name()))
+		}
+		if l.maxCount > 0 && id > l.maxCount {
+			continue
+		}
+		if fi.ModTime().After(latest) || (fi.ModTime().Equal(latest) && id > l.lastID) {
+			latest = fi.ModTime()
+			l.lastID = id
 		}
 	}
+	return
+}
 
-	id += 1
-
+func (l *LogFile) nextArchiveID() int64 {
+	id := l.lastID + 1
 	if l.maxCount > 0 && id > l.maxCount {
 		id = 1
 	}
-	return
+	return id
+}
+
+// removeExpired 删除超过 maxAge 的归档文件
+func (l *LogFile) removeExpired() {
+	if l.maxAge <= 0 {
+		return
+	}
+	fis, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, fi := range fis {
+		if _, ok := l.archiveID(fi.Name()); !ok {
+			continue
+		}
+		if now.Sub(fi.ModTime()) > l.maxAge {
+			_ = os.Remove(filepath.Join(l.dir, fi.Name()))
+		}
+	}
+}
+
+// compressArchive 使用 gzip 压缩归档文件，并删除原文件
+func compressArchive(name string) (err error) {
+	var src *os.File
+	if src, err = os.Open(name); err != nil {
+		return
+	}
+	defer src.Close()
+
+	var fi os.FileInfo
+	if fi, err = src.Stat(); err != nil {
+		return
+	}
+
+	var dst *os.File
+	if dst, err = os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		return
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err == nil {
+		err = zw.Close()
+	}
+	if err1 := dst.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		_ = os.Remove(name + ".gz")
+		return
+	}
+	// 编号循环使用时，压缩期间原文件可能已经被新的归档文件替换
+	if cur, err1 := os.Stat(name); err1 == nil && !os.SameFile(fi, cur) {
+		return
+	}
+	return os.Remove(name)
+}
+
+// runArchiver 后台压缩归档文件，清理过期的归档文件
+func (l *LogFile) runArchiver() {
+	defer close(l.done)
+	for name := range l.archives {
+		if l.compress {
+			_ = compressArchive(name)
+		}
+		l.removeExpired()
+	}
 }
 
 func (l *LogFile) open() (err error) {
@@ -79,45 +217,55 @@ func (l *LogFile) open() (err error) {
 	return
 }
 
+// reallocate 归档当前文件，并打开新的文件，调用时必须持有 l
 func (l *LogFile) reallocate() (err error) {
-	l.l.Lock()
-	defer l.l.Unlock()
+	id := l.nextArchiveID()
 
-	var info os.FileInfo
+	// try remove existed, in case id looped due to maxCount
+	_ = os.Remove(l.archiveFileName(id))
+	_ = os.Remove(l.archiveFileName(id) + ".gz")
 
-	if info, err = l.currentFile.Stat(); err != nil {
+	if err = l.currentFile.Close(); err != nil {
 		return
 	}
+	l.currentFile = nil
 
-	if info.Size() <= l.maxSize {
+	renameErr := os.Rename(l.currentFileName(), l.archiveFileName(id))
+
+	// 即便重命名失败，也要重新打开文件，保证后续写入
+	if err = l.open(); err != nil {
 		return
 	}
-
-	var id int64
-	if id, err = l.nextArchiveId(); err != nil {
+	if err = renameErr; err != nil {
 		return
 	}
 
-	// try remove existed, in case id looped due to maxCount
-	_ = os.Remove(l.archiveFileName(id))
+	l.lastID = id
+	l.archives <- l.archiveFileName(id)
+	return
+}
 
-	if err = os.Rename(l.currentFileName(), l.archiveFileName(id)); err != nil {
-		return
-	}
+func (l *LogFile) Write(p []byte) (n int, err error) {
+	l.l.Lock()
+	defer l.l.Unlock()
 
-	if err = l.open(); err != nil {
+	if l.closed {
+		err = ErrLogFileClosed
 		return
 	}
 
-	return nil
-}
+	// 上次归档后未能重新打开文件
+	if l.currentFile == nil {
+		if err = l.open(); err != nil {
+			return
+		}
+	}
 
-func (l *LogFile) Write(p []byte) (n int, err error) {
 	if n, err = l.currentFile.Write(p); err != nil {
 		return
 	}
 
-	atomic.AddInt64(&l.currentSize, int64(n))
+	l.currentSize += int64(n)
 
 	if l.currentSize > l.maxSize {
 		if err = l.reallocate(); err != nil {
@@ -128,20 +276,56 @@ func (l *LogFile) Write(p []byte) (n int, err error) {
 	return
 }
 
-func (l *LogFile) Close() error {
-	return l.currentFile.Close()
+// Close 关闭文件，并等待后台的压缩完成
+func (l *LogFile) Close() (err error) {
+	l.l.Lock()
+	if l.closed {
+		l.l.Unlock()
+		return ErrLogFileClosed
+	}
+	if l.currentFile != nil {
+		err = l.currentFile.Close()
+		l.currentFile = nil
+	}
+	l.closed = true
+	close(l.archives)
+	l.l.Unlock()
+
+	<-l.done
+	return
 }
 
 func NewLogFile(dir, name string, maxSize int64, maxCount int64) (lf *LogFile, err error) {
+	return NewLogFileWithOptions(dir, name, LogFileOptions{MaxSize: maxSize, MaxFiles: maxCount})
+}
+
+func NewLogFileWithOptions(dir, name string, opts LogFileOptions) (lf *LogFile, err error) {
 	lf = &LogFile{
 		dir:      dir,
 		name:     name,
-		maxSize:  maxSize,
-		maxCount: maxCount,
+		maxSize:  opts.MaxSize,
+		maxCount: opts.MaxFiles,
+		maxAge:   opts.MaxAge,
+		compress: opts.Compress,
+		archives: make(chan string, logFileArchiveQueue),
+		done:     make(chan struct{}),
 		l:        &sync.Mutex{},
 	}
+	var uncompressed []string
+	if uncompressed, err = lf.scanArchives(); err != nil {
+		return
+	}
 	if err = lf.open(); err != nil {
 		return
 	}
+	go lf.runArchiver()
+
+	// 上次退出时尚未压缩的归档文件
+	if lf.compress {
+		for _, name := range uncompressed {
+			lf.archives <- name
+		}
+	}
+	lf.removeExpired()
 	return
 }
@@ -1,16 +1,23 @@
 package mlog
 
 import (
+	"compress/gzip"
+	"fmt"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestNewLogFile(t *testing.T) {
-	_ = os.RemoveAll(filepath.Join("testdata", "logs"))
-	_ = os.MkdirAll(filepath.Join("testdata", "logs"), 0755)
-	f, err := NewLogFile(filepath.Join("testdata", "logs"), "test", 10, 0)
+	dir := t.TempDir()
+
+	f, err := NewLogFile(dir, "test", 10, 0)
 	require.NoError(t, err)
 	_, err = f.Write([]byte("hello, world, hello, world, hello, world"))
 	require.NoError(t, err)
@@ -20,7 +27,7 @@ func TestNewLogFile(t *testing.T) {
 	require.NoError(t, err)
 	err = f.Close()
 	require.NoError(t, err)
-	f, err = NewLogFile(filepath.Join("testdata", "logs"), "test-maxcount", 10, 2)
+	f, err = NewLogFile(dir, "test-maxcount", 10, 2)
 	require.NoError(t, err)
 	_, err = f.Write([]byte("hello, world, hello, world, hello, world"))
 	require.NoError(t, err)
@@ -31,3 +38,137 @@ func TestNewLogFile(t *testing.T) {
 	err = f.Close()
 	require.NoError(t, err)
 }
+
+func TestLogFileCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mlog-compress")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// 过期的归档文件在打开时删除
+	expired := filepath.Join(dir, "test.7.log.gz")
+	require.NoError(t, ioutil.WriteFile(expired, []byte("expired"), 0644))
+	old := time.Now().Add(-time.Hour * 48)
+	require.NoError(t, os.Chtimes(expired, old, old))
+
+	f, err := NewLogFileWithOptions(dir, "test", LogFileOptions{
+		MaxSize:  10,
+		MaxFiles: 3,
+		MaxAge:   time.Hour * 24,
+		Compress: true,
+	})
+	require.NoError(t, err)
+	_, err = os.Stat(expired)
+	require.True(t, os.IsNotExist(err))
+
+	_, err = f.Write([]byte("hello, world, hello, world"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = os.Stat(filepath.Join(dir, "test.1.log"))
+	require.True(t, os.IsNotExist(err))
+
+	zf, err := os.Open(filepath.Join(dir, "test.1.log.gz"))
+	require.NoError(t, err)
+	defer zf.Close()
+	zr, err := gzip.NewReader(zf)
+	require.NoError(t, err)
+	buf, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+	require.Equal(t, "hello, world, hello, world", string(buf))
+}
+
+func TestLogFileConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mlog-concurrent")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f, err := NewLogFileWithOptions(dir, "test", LogFileOptions{
+		MaxSize:  256,
+		Compress: true,
+	})
+	require.NoError(t, err)
+
+	const (
+		writers = 8
+		lines   = 200
+	)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < lines; j++ {
+				_, err := f.Write([]byte(fmt.Sprintf("writer %d line %d\n", i, j)))
+				// require 只能在运行测试的 goroutine 中调用
+				assert.NoError(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.NoError(t, f.Close())
+
+	_, err = f.Write([]byte("closed\n"))
+	require.Equal(t, ErrLogFileClosed, err)
+
+	// 所有的行都完整地写入了当前文件或者压缩后的归档文件
+	fis, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	seen := map[string]bool{}
+	for _, fi := range fis {
+		var buf []byte
+		switch {
+		case fi.Name() == "test.log":
+			buf, err = ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+			require.NoError(t, err)
+		case strings.HasSuffix(fi.Name(), ".log.gz"):
+			zf, err := os.Open(filepath.Join(dir, fi.Name()))
+			require.NoError(t, err)
+			zr, err := gzip.NewReader(zf)
+			require.NoError(t, err)
+			buf, err = ioutil.ReadAll(zr)
+			require.NoError(t, err)
+			_ = zf.Close()
+		default:
+			t.Fatalf("unexpected file: %s", fi.Name())
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(buf)), "\n") {
+			if line != "" {
+				require.False(t, seen[line], line)
+				seen[line] = true
+			}
+		}
+	}
+	require.Len(t, seen, writers*lines)
+}
+
+func TestLogFileArchiveID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mlog-archive-id")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(f *LogFile) {
+		_, err := f.Write([]byte("hello, world\n"))
+		require.NoError(t, err)
+	}
+
+	f, err := NewLogFile(dir, "test", 10, 3)
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		write(f)
+	}
+	require.NoError(t, f.Close())
+	// 编号循环使用，第 4 次归档使用编号 1
+	require.Equal(t, int64(1), f.lastID)
+
+	// 重新打开后，从修改时间最新的归档文件继续编号
+	old := time.Now().Add(-time.Hour)
+	for _, id := range []int64{2, 3} {
+		require.NoError(t, os.Chtimes(f.archiveFileName(id), old, old))
+	}
+	f, err = NewLogFile(dir, "test", 10, 3)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), f.lastID)
+	write(f)
+	require.NoError(t, f.Close())
+	require.Equal(t, int64(2), f.lastID)
+}
@@ -0,0 +1,92 @@
+package mlog
+
+import (
+	"sync"
+)
+
+const (
+	LogTailSize = 1000
+)
+
+// logTail 保存最近的日志行，并分发给订阅者，每次 Write 调用对应一行日志
+type logTail struct {
+	lines [][]byte
+	next  int
+
+	subscribers map[chan []byte]struct{}
+
+	l sync.Locker
+}
+
+func newLogTail() *logTail {
+	return &logTail{
+		lines:       make([][]byte, 0, LogTailSize),
+		subscribers: map[chan []byte]struct{}{},
+		l:           &sync.Mutex{},
+	}
+}
+
+func (t *logTail) Write(p []byte) (n int, err error) {
+	line := make([]byte, len(p))
+	copy(line, p)
+
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	if len(t.lines) < LogTailSize {
+		t.lines = append(t.lines, line)
+	} else {
+		t.lines[t.next] = line
+		t.next = (t.next + 1) % LogTailSize
+	}
+
+	for ch := range t.subscribers {
+		// 订阅者处理过慢时丢弃日志，避免阻塞进程输出
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+
+	n = len(p)
+	return
+}
+
+func (t *logTail) recent(count int) [][]byte {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	return t.recentLocked(count)
+}
+
+func (t *logTail) recentLocked(count int) [][]byte {
+	lines := make([][]byte, 0, len(t.lines))
+	lines = append(lines, t.lines[t.next:]...)
+	lines = append(lines, t.lines[:t.next]...)
+	if count >= 0 && len(lines) > count {
+		lines = lines[len(lines)-count:]
+	}
+	return lines
+}
+
+func (t *logTail) subscribe() (ch chan []byte, cancel func()) {
+	_, ch, cancel = t.subscribeRecent(0)
+	return
+}
+
+// subscribeRecent 在同一把锁内取得最近的日志并订阅，新的日志不会遗漏，也不会重复
+func (t *logTail) subscribeRecent(count int) (lines [][]byte, ch chan []byte, cancel func()) {
+	ch = make(chan []byte, 256)
+
+	t.l.Lock()
+	lines = t.recentLocked(count)
+	t.subscribers[ch] = struct{}{}
+	t.l.Unlock()
+
+	cancel = func() {
+		t.l.Lock()
+		delete(t.subscribers, ch)
+		t.l.Unlock()
+	}
+	return
+}
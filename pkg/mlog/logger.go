@@ -3,6 +3,7 @@ package mlog
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -11,7 +12,15 @@ import (
 )
 
 const (
-	LoggerDateLayout = "15:04:05.000"
+	LoggerDateLayout     = "15:04:05.000"
+	LoggerJSONDateLayout = "2006-01-02T15:04:05.000Z07:00"
+
+	FormatText = "text"
+	FormatJSON = "json"
+
+	StreamOut   = "out"
+	StreamErr   = "err"
+	StreamMinit = "minit"
 )
 
 var (
@@ -24,51 +33,208 @@ var (
 	loggerNow = time.Now
 )
 
+// LoggerOptions 日志选项
+type LoggerOptions struct {
+	// Dir 日志目录
+	Dir string
+	// Filename 日志文件名，实际文件名会追加 .out 和 .err
+	Filename string
+	// Name 单元名称
+	Name string
+	// Kind 单元类型，可以为空
+	Kind string
+	// Format 日志格式，text 或者 json，默认为 text
+	Format string
+
+	// MaxFileSize 单个日志文件的最大大小，为 0 时使用默认值 64MB
+	MaxFileSize int64
+	// MaxFiles 保留的归档文件数量，为 0 时使用默认值 3，小于 0 时不限制
+	MaxFiles int64
+	// MaxAge 归档文件的最长保留时间，为 0 时不限制
+	MaxAge time.Duration
+	// Compress 使用 gzip 压缩归档文件
+	Compress bool
+	// Merge 将标准输出和标准错误写入同一个文件 Filename.log
+	Merge bool
+	// DisableFile 不写入日志文件
+	DisableFile bool
+	// DisableConsole 不输出到控制台
+	DisableConsole bool
+}
+
+// logEntry JSON 格式的日志行
+type logEntry struct {
+	Time    string `json:"time"`
+	Unit    string `json:"unit"`
+	Kind    string `json:"kind,omitempty"`
+	Stream  string `json:"stream"`
+	PID     int    `json:"pid"`
+	Message string `json:"message"`
+}
+
 type Logger struct {
 	namePrefix []byte
 
-	out io.Writer
-	err io.Writer
+	name   string
+	kind   string
+	format string
+	// pid minit 自身的进程号，用于 minit 输出的信息
+	pid int
+
+	out   io.Writer
+	err   io.Writer
+	tail  *logTail
+	files []*LogFile
 }
 
 func NewLogger(dir, name, filename string) (logger *Logger, err error) {
+	return NewLoggerWithOptions(LoggerOptions{
+		Dir:      dir,
+		Filename: filename,
+		Name:     name,
+	})
+}
+
+func NewLoggerWithOptions(opts LoggerOptions) (logger *Logger, err error) {
+	switch opts.Format {
+	case "":
+		opts.Format = FormatText
+	case FormatText, FormatJSON:
+	default:
+		err = fmt.Errorf("未知的日志格式: %s", opts.Format)
+		return
+	}
+	prefix := opts.Name
+	if opts.Kind != "" {
+		prefix = opts.Kind + "/" + opts.Name
+	}
 	logger = &Logger{
-		namePrefix: []byte(" [" + name + "] "),
+		namePrefix: []byte(" [" + prefix + "] "),
+		name:       opts.Name,
+		kind:       opts.Kind,
+		format:     opts.Format,
+		pid:        os.Getpid(),
+		tail:       newLogTail(),
 	}
-	var outFile, errFile *LogFile
-	if outFile, err = NewLogFile(dir, filename+".out", 64*1024*1024, 3); err != nil {
-		return
+	var outs, errs []io.Writer
+
+	if !opts.DisableConsole {
+		outs = append(outs, os.Stdout)
+		errs = append(errs, os.Stderr)
 	}
-	if errFile, err = NewLogFile(dir, filename+".err", 64*1024*1024, 3); err != nil {
-		return
+
+	if !opts.DisableFile {
+		fileOpts := LogFileOptions{
+			MaxSize:  opts.MaxFileSize,
+			MaxFiles: opts.MaxFiles,
+			MaxAge:   opts.MaxAge,
+			Compress: opts.Compress,
+		}
+		if fileOpts.MaxSize == 0 {
+			fileOpts.MaxSize = DefaultLogFileMaxSize
+		}
+		if fileOpts.MaxFiles == 0 {
+			fileOpts.MaxFiles = DefaultLogFileMaxFiles
+		}
+		if opts.Merge {
+			var file *LogFile
+			if file, err = NewLogFileWithOptions(opts.Dir, opts.Filename, fileOpts); err != nil {
+				return
+			}
+			outs = append(outs, file)
+			errs = append(errs, file)
+			logger.files = append(logger.files, file)
+		} else {
+			var outFile, errFile *LogFile
+			if outFile, err = NewLogFileWithOptions(opts.Dir, opts.Filename+".out", fileOpts); err != nil {
+				return
+			}
+			if errFile, err = NewLogFileWithOptions(opts.Dir, opts.Filename+".err", fileOpts); err != nil {
+				return
+			}
+			outs = append(outs, outFile)
+			errs = append(errs, errFile)
+			logger.files = append(logger.files, outFile, errFile)
+		}
+	}
+
+	logger.out = io.MultiWriter(append(outs, logger.tail)...)
+	logger.err = io.MultiWriter(append(errs, logger.tail)...)
+	return
+}
+
+// Close 关闭日志文件，关闭后不应再写入日志
+func (l *Logger) Close() (err error) {
+	for _, file := range l.files {
+		if err1 := file.Close(); err1 != nil && err == nil {
+			err = err1
+		}
 	}
-	logger.out = io.MultiWriter(os.Stdout, outFile)
-	logger.err = io.MultiWriter(os.Stderr, errFile)
 	return
 }
 
+// Recent 返回最近的 count 行日志，包括标准输出和标准错误，count 小于 0 时返回所有保存的日志
+func (l *Logger) Recent(count int) [][]byte {
+	return l.tail.recent(count)
+}
+
+// Subscribe 订阅新的日志行，使用完毕后调用 cancel 取消订阅
+func (l *Logger) Subscribe() (ch <-chan []byte, cancel func()) {
+	return l.tail.subscribe()
+}
+
+// SubscribeRecent 返回最近的 count 行日志，并订阅此后的日志行，使用完毕后调用 cancel 取消订阅
+func (l *Logger) SubscribeRecent(count int) (lines [][]byte, ch <-chan []byte, cancel func()) {
+	return l.tail.subscribeRecent(count)
+}
+
 func (l *Logger) Print(items ...interface{}) {
-	appendLogLine(l.namePrefix, append([]byte(fmt.Sprint(items...)), '\n'), l.out)
+	l.appendLine(StreamMinit, l.pid, append([]byte(fmt.Sprint(items...)), '\n'), l.out)
 }
 
 func (l *Logger) Error(items ...interface{}) {
-	appendLogLine(l.namePrefix, append([]byte(fmt.Sprint(items...)), '\n'), l.err)
+	l.appendLine(StreamMinit, l.pid, append([]byte(fmt.Sprint(items...)), '\n'), l.err)
 }
 
 func (l *Logger) Printf(pattern string, items ...interface{}) {
-	appendLogLine(l.namePrefix, append([]byte(fmt.Sprintf(pattern, items...)), '\n'), l.out)
+	l.appendLine(StreamMinit, l.pid, append([]byte(fmt.Sprintf(pattern, items...)), '\n'), l.out)
 }
 
 func (l *Logger) Errorf(pattern string, items ...interface{}) {
-	appendLogLine(l.namePrefix, append([]byte(fmt.Sprintf(pattern, items...)), '\n'), l.err)
+	l.appendLine(StreamMinit, l.pid, append([]byte(fmt.Sprintf(pattern, items...)), '\n'), l.err)
 }
 
-func (l *Logger) StreamOut(r io.Reader) {
-	streamLogLine(l.namePrefix, r, l.out)
+// WriteOut 以标准输出的身份输出一行，用于转发不是由子进程产生的内容，比如 logcollect 单元收集的日志
+func (l *Logger) WriteOut(line string) {
+	l.appendLine(StreamOut, l.pid, append([]byte(line), '\n'), l.out)
 }
 
-func (l *Logger) StreamErr(r io.Reader) {
-	streamLogLine(l.namePrefix, r, l.err)
+// StreamOut 逐行输出进程 pid 的标准输出
+func (l *Logger) StreamOut(pid int, r io.Reader) {
+	streamLogLine(r, func(b []byte) {
+		l.appendLine(StreamOut, pid, b, l.out)
+	})
+}
+
+// StreamErr 逐行输出进程 pid 的标准错误
+func (l *Logger) StreamErr(pid int, r io.Reader) {
+	streamLogLine(r, func(b []byte) {
+		l.appendLine(StreamErr, pid, b, l.err)
+	})
+}
+
+func (l *Logger) appendLine(stream string, pid int, b []byte, w io.Writer) {
+	if l.format == FormatJSON {
+		appendJSONLogLine(logEntry{
+			Unit:    l.name,
+			Kind:    l.kind,
+			Stream:  stream,
+			PID:     pid,
+			Message: string(bytes.TrimRight(b, "\r\n")),
+		}, w)
+	} else {
+		appendLogLine(l.namePrefix, b, w)
+	}
 }
 
 func appendLogLine(name, b []byte, w io.Writer) {
@@ -80,15 +246,29 @@ func appendLogLine(name, b []byte, w io.Writer) {
 	buf.Reset()
 	loggerBuffers.Put(buf)
 }
-func streamLogLine(name []byte, r io.Reader, w io.Writer) {
+
+func appendJSONLogLine(e logEntry, w io.Writer) {
+	e.Time = loggerNow().Format(LoggerJSONDateLayout)
+	buf := loggerBuffers.Get().(*bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	// Encode 会在末尾追加换行符，保证每行一个 JSON 对象
+	if err := enc.Encode(e); err == nil {
+		_, _ = w.Write(buf.Bytes())
+	}
+	buf.Reset()
+	loggerBuffers.Put(buf)
+}
+
+func streamLogLine(r io.Reader, fn func(b []byte)) {
 	br := bufio.NewReader(r)
 	for {
 		b, err := br.ReadBytes('\n')
 		if err == nil {
-			appendLogLine(name, b, w)
+			fn(b)
 		} else {
 			if len(b) != 0 {
-				appendLogLine(name, append(b, '\n'), w)
+				fn(append(b, '\n'))
 			}
 			break
 		}
@@ -1,16 +1,133 @@
 package mlog
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/stretchr/testify/require"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLog(t *testing.T) {
-	log, err := NewLogger(os.TempDir(), "test", "test")
+	log, err := NewLogger(t.TempDir(), "test", "test")
 	require.NoError(t, err)
+	defer log.Close()
 	log.Print("hello", "world")
 	log.Printf("hello, %s", "world")
 	log.Error("error", "world")
 	log.Errorf("error, %s", "world")
 }
+
+func TestLoggerTail(t *testing.T) {
+	log, err := NewLogger(t.TempDir(), "test", "test-tail")
+	require.NoError(t, err)
+	defer log.Close()
+	ch, cancel := log.Subscribe()
+	defer cancel()
+	for i := 0; i < LogTailSize+10; i++ {
+		log.Printf("line %d", i)
+	}
+	log.Errorf("error line")
+	lines := log.Recent(2)
+	require.Len(t, lines, 2)
+	require.Contains(t, string(lines[0]), fmt.Sprintf("line %d", LogTailSize+9))
+	require.Contains(t, string(lines[1]), "error line")
+	require.Len(t, log.Recent(-1), LogTailSize)
+	require.Contains(t, string(<-ch), "line 0")
+}
+
+func TestLoggerSubscribeRecent(t *testing.T) {
+	log, err := NewLogger(t.TempDir(), "test", "test-subscribe")
+	require.NoError(t, err)
+	defer log.Close()
+	log.Printf("line 1")
+	log.Printf("line 2")
+	lines, ch, cancel := log.SubscribeRecent(1)
+	defer cancel()
+	log.Printf("line 3")
+	require.Len(t, lines, 1)
+	require.Contains(t, string(lines[0]), "line 2")
+	require.Contains(t, string(<-ch), "line 3")
+	require.Len(t, ch, 0)
+}
+
+func TestLoggerJSON(t *testing.T) {
+	dir := t.TempDir()
+	log, err := NewLoggerWithOptions(LoggerOptions{
+		Dir:      dir,
+		Filename: "test-json",
+		Name:     "test",
+		Kind:     "daemon",
+		Format:   FormatJSON,
+	})
+	require.NoError(t, err)
+	defer log.Close()
+	log.Printf("hello, <%s>", "world")
+	log.StreamOut(42, strings.NewReader("line 1\r\nline 2"))
+	log.StreamErr(42, strings.NewReader("error line\n"))
+	log.WriteOut("collected line")
+
+	lines := log.Recent(-1)
+	require.Len(t, lines, 5)
+
+	var entries []logEntry
+	for _, line := range lines {
+		require.True(t, strings.HasSuffix(string(line), "}\n"))
+		var e logEntry
+		require.NoError(t, json.Unmarshal(line, &e))
+		_, err = time.Parse(time.RFC3339, e.Time)
+		require.NoError(t, err)
+		e.Time = ""
+		entries = append(entries, e)
+	}
+	require.Equal(t, []logEntry{
+		{Unit: "test", Kind: "daemon", Stream: StreamMinit, PID: os.Getpid(), Message: "hello, <world>"},
+		{Unit: "test", Kind: "daemon", Stream: StreamOut, PID: 42, Message: "line 1"},
+		{Unit: "test", Kind: "daemon", Stream: StreamOut, PID: 42, Message: "line 2"},
+		{Unit: "test", Kind: "daemon", Stream: StreamErr, PID: 42, Message: "error line"},
+		{Unit: "test", Kind: "daemon", Stream: StreamOut, PID: os.Getpid(), Message: "collected line"},
+	}, entries)
+	require.Contains(t, string(lines[0]), "<world>")
+
+	_, err = NewLoggerWithOptions(LoggerOptions{Dir: dir, Filename: "test-json", Name: "test", Format: "xml"})
+	require.Error(t, err)
+}
+
+func TestLoggerMerge(t *testing.T) {
+	dir := t.TempDir()
+	log, err := NewLoggerWithOptions(LoggerOptions{
+		Dir:            dir,
+		Filename:       "test",
+		Name:           "test",
+		Merge:          true,
+		DisableConsole: true,
+	})
+	require.NoError(t, err)
+	defer log.Close()
+	log.Printf("out line")
+	log.Errorf("err line")
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "test.log"))
+	require.NoError(t, err)
+	require.Contains(t, string(buf), "out line")
+	require.Contains(t, string(buf), "err line")
+	_, err = os.Stat(filepath.Join(dir, "test.out.log"))
+	require.True(t, os.IsNotExist(err))
+
+	log, err = NewLoggerWithOptions(LoggerOptions{
+		Dir:         dir,
+		Filename:    "test-nofile",
+		Name:        "test",
+		DisableFile: true,
+	})
+	require.NoError(t, err)
+	defer log.Close()
+	log.Printf("hello")
+	require.Len(t, log.Recent(-1), 1)
+	_, err = os.Stat(filepath.Join(dir, "test-nofile.out.log"))
+	require.True(t, os.IsNotExist(err))
+}
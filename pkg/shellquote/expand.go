@@ -0,0 +1,197 @@
+package shellquote
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	UnterminatedBraceError = errors.New("unterminated parameter expansion")
+)
+
+// BadSubstitutionError is returned by Expand when a braced parameter
+// expansion is malformed, for example ${} or ${1abc}.
+type BadSubstitutionError struct {
+	Expr string
+}
+
+func (e *BadSubstitutionError) Error() string {
+	return "bad substitution: ${" + e.Expr + "}"
+}
+
+// ParameterError is returned by Expand when a parameter expanded with
+// ${name:?message} or ${name?message} is unset or null.
+type ParameterError struct {
+	Name    string
+	Message string
+}
+
+func (e *ParameterError) Error() string {
+	if e.Message == "" {
+		return e.Name + ": parameter null or not set"
+	}
+	return e.Name + ": " + e.Message
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// isSpecialName reports whether c is a one-character special parameter, such
+// as $$, $* or $1, as recognized by os.Expand.
+func isSpecialName(c byte) bool {
+	switch c {
+	case '*', '#', '$', '@', '!', '?', '-':
+		return true
+	}
+	return c >= '0' && c <= '9'
+}
+
+// Expand replaces $name and ${name} in the input according to /bin/sh's
+// parameter expansion rules, looking up values with the given function.
+//
+// The following forms are supported, where the colon variants also treat an
+// empty value as unset:
+//
+//	${name:-word}  ${name-word}  use word if name is unset
+//	${name:=word}  ${name=word}  same as above, name is never assigned
+//	${name:?word}  ${name?word}  fail with a *ParameterError if name is unset
+//	${name:+word}  ${name+word}  use word if name is set, otherwise empty
+//
+// The word is expanded recursively, and only when it is used. As with
+// os.Expand, a $ followed by one of *#$@!?- or a digit looks up that single
+// character as the name, so $$ and $1 are usually expanded to an empty string.
+// A $ not followed by a name or a brace is kept as is. Quotes and backslashes
+// have no special meaning.
+func Expand(input string, lookup func(name string) (string, bool)) (string, error) {
+	var buf strings.Builder
+
+	for i := 0; i < len(input); {
+		c := input[i]
+		if c != '$' || i+1 >= len(input) {
+			buf.WriteByte(c)
+			i++
+			continue
+		}
+
+		next := input[i+1]
+		switch {
+		case isSpecialName(next):
+			val, _ := lookup(input[i+1 : i+2])
+			buf.WriteString(val)
+			i += 2
+		case next == '{':
+			end, err := matchBrace(input, i+2)
+			if err != nil {
+				return "", err
+			}
+			val, err := expandBraced(input[i+2:end], lookup)
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(val)
+			i = end + 1
+		case isNameStart(next):
+			j := i + 1
+			for j < len(input) && isNameChar(input[j]) {
+				j++
+			}
+			val, _ := lookup(input[i+1 : j])
+			buf.WriteString(val)
+			i = j
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+
+	return buf.String(), nil
+}
+
+// matchBrace returns the index of the brace closing a parameter expansion
+// whose content starts at start, taking nested expansions into account.
+func matchBrace(input string, start int) (int, error) {
+	var depth int
+	for j := start; j < len(input); j++ {
+		switch input[j] {
+		case '$':
+			if j+1 < len(input) && (input[j+1] == '{' || input[j+1] == '$') {
+				if input[j+1] == '{' {
+					depth++
+				}
+				j++
+			}
+		case '}':
+			if depth == 0 {
+				return j, nil
+			}
+			depth--
+		}
+	}
+	return 0, UnterminatedBraceError
+}
+
+func expandBraced(expr string, lookup func(name string) (string, bool)) (string, error) {
+	var j int
+	switch {
+	case expr == "":
+	case expr[0] >= '0' && expr[0] <= '9':
+		for j < len(expr) && expr[j] >= '0' && expr[j] <= '9' {
+			j++
+		}
+	case isSpecialName(expr[0]):
+		j = 1
+	default:
+		for j < len(expr) && isNameChar(expr[j]) {
+			j++
+		}
+	}
+	name, rest := expr[:j], expr[j:]
+	if name == "" {
+		return "", &BadSubstitutionError{Expr: expr}
+	}
+
+	val, set := lookup(name)
+	if rest == "" {
+		return val, nil
+	}
+
+	var colon bool
+	if rest[0] == ':' {
+		colon, rest = true, rest[1:]
+	}
+	if rest == "" {
+		return "", &BadSubstitutionError{Expr: expr}
+	}
+	op, word := rest[0], rest[1:]
+
+	null := !set || (colon && val == "")
+
+	switch op {
+	case '-', '=':
+		if null {
+			return Expand(word, lookup)
+		}
+		return val, nil
+	case '?':
+		if null {
+			msg, err := Expand(word, lookup)
+			if err != nil {
+				return "", err
+			}
+			return "", &ParameterError{Name: name, Message: msg}
+		}
+		return val, nil
+	case '+':
+		if null {
+			return "", nil
+		}
+		return Expand(word, lookup)
+	default:
+		return "", &BadSubstitutionError{Expr: expr}
+	}
+}
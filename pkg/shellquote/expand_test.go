@@ -0,0 +1,114 @@
+package shellquote
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+var expandEnv = map[string]string{
+	"HOME":  "/root",
+	"PORT":  "8080",
+	"EMPTY": "",
+	"_X1":   "x1",
+	"1":     "one",
+	"$":     "pid",
+}
+
+func expandLookup(name string) (string, bool) {
+	val, ok := expandEnv[name]
+	return val, ok
+}
+
+func TestExpand(t *testing.T) {
+	for _, elem := range expandTest {
+		output, err := Expand(elem.input, expandLookup)
+		if err != nil {
+			t.Errorf("Input %q, got error %#v", elem.input, err)
+		} else if output != elem.output {
+			t.Errorf("Input %q, got %q, expected %q", elem.input, output, elem.output)
+		}
+	}
+}
+
+func TestExpandLikeOSExpand(t *testing.T) {
+	mapping := func(name string) string {
+		val, _ := expandLookup(name)
+		return val
+	}
+	for _, input := range []string{
+		"$HOME/bin", "${HOME}bin", "$$", "$$HOME", "$1", "$10", "${1}", "$*", "$@", "$#", "$!", "$?", "$-",
+		"awk '{print $1}'", "cost $5 or $", "$%", "a$", "$_X1-$MISSING-",
+	} {
+		expected := os.Expand(input, mapping)
+		output, err := Expand(input, expandLookup)
+		if err != nil {
+			t.Errorf("Input %q, got error %#v", input, err)
+		} else if output != expected {
+			t.Errorf("Input %q, got %q, os.Expand got %q", input, output, expected)
+		}
+	}
+}
+
+func TestErrorExpand(t *testing.T) {
+	for _, elem := range errorExpandTest {
+		_, err := Expand(elem.input, expandLookup)
+		if !reflect.DeepEqual(err, elem.error) {
+			t.Errorf("Input %q, got error %#v, expected error %#v", elem.input, err, elem.error)
+		}
+	}
+}
+
+var expandTest = []struct {
+	input  string
+	output string
+}{
+	{"hello", "hello"},
+	{"$HOME/bin", "/root/bin"},
+	{"${HOME}bin", "/rootbin"},
+	{"$_X1-$MISSING-", "x1--"},
+	{"${PORT:-80}", "8080"},
+	{"${MISSING:-80}", "80"},
+	{"${EMPTY:-80}", "80"},
+	{"${EMPTY-80}", ""},
+	{"${MISSING-80}", "80"},
+	{"${MISSING:=80}", "80"},
+	{"${PORT:?required}", "8080"},
+	{"${EMPTY?required}", ""},
+	{"${PORT:+set}", "set"},
+	{"${EMPTY:+set}", ""},
+	{"${EMPTY+set}", "set"},
+	{"${MISSING+set}", ""},
+	{"${MISSING:-$HOME/${PORT}}", "/root/8080"},
+	{"${MISSING:-${ALSO_MISSING:-deep}}", "deep"},
+	{"${PORT:-${MISSING:?lazy}}", "8080"},
+	{"${MISSING:-a:b}", "a:b"},
+	// special parameters are one-character names, as in os.Expand
+	{"$$HOME", "pidHOME"},
+	{"${MISSING:-$$}", "pid"},
+	{"$1$2", "one"},
+	{"$10", "one0"},
+	{"${1}", "one"},
+	{"${2:-two}", "two"},
+	{"$*$@$#$!$?$-", ""},
+	{"cost $5 or $", "cost  or $"},
+	{"awk '{print $1}'", "awk '{print one}'"},
+	{"100%$", "100%$"},
+	{"$%", "$%"},
+	{"${MISSING:-}", ""},
+}
+
+var errorExpandTest = []struct {
+	input string
+	error error
+}{
+	{"${HOME", UnterminatedBraceError},
+	{"${MISSING:-${HOME}", UnterminatedBraceError},
+	{"${}", &BadSubstitutionError{Expr: ""}},
+	{"${1abc}", &BadSubstitutionError{Expr: "1abc"}},
+	{"${HOME:}", &BadSubstitutionError{Expr: "HOME:"}},
+	{"${HOME%/*}", &BadSubstitutionError{Expr: "HOME%/*"}},
+	{"${MISSING:?}", &ParameterError{Name: "MISSING"}},
+	{"${EMPTY:?must set $HOME}", &ParameterError{Name: "EMPTY", Message: "must set /root"}},
+	{"${MISSING?missing}", &ParameterError{Name: "MISSING", Message: "missing"}},
+}
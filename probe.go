@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultProbeInterval         = time.Second
+	DefaultProbeTimeout          = time.Second
+	DefaultProbeSuccessThreshold = 1
+	DefaultProbeFailureThreshold = 3
+)
+
+type HTTPProbe struct {
+	URL    string `yaml:"url"`    // 请求地址，使用 GET 方法
+	Status int    `yaml:"status"` // 期望的状态码，默认 200 至 399 均视为成功
+}
+
+// Probe 探针，tcp, http, exec, file 只能指定其中一种
+type Probe struct {
+	TCP  string     `yaml:"tcp"`  // TCP 连接地址，比如 127.0.0.1:3306
+	HTTP *HTTPProbe `yaml:"http"` // HTTP GET 请求
+	Exec []string   `yaml:"exec"` // 执行命令，退出码为 0 视为成功
+	File string     `yaml:"file"` // 文件存在视为成功
+
+	InitialDelay     time.Duration `yaml:"initial_delay"`     // 进程启动后，首次检查前的等待时间
+	Interval         time.Duration `yaml:"interval"`          // 检查间隔，默认 1s
+	Timeout          time.Duration `yaml:"timeout"`           // 单次检查超时时间，默认 1s
+	SuccessThreshold int           `yaml:"success_threshold"` // 连续成功次数，默认 1
+	FailureThreshold int           `yaml:"failure_threshold"` // 连续失败次数，默认 3
+}
+
+// checkProbe 检查探针配置，field 为字段名
+func checkProbe(p *Probe, field string) error {
+	if p == nil {
+		return nil
+	}
+	var count int
+	if p.TCP != "" {
+		count++
+	}
+	if p.HTTP != nil {
+		if p.HTTP.URL == "" {
+			return fmt.Errorf("没有指定 HTTP 地址，检查 %s.http.url 字段", field)
+		}
+		count++
+	}
+	if len(p.Exec) > 0 {
+		count++
+	}
+	if p.File != "" {
+		count++
+	}
+	if count != 1 {
+		return fmt.Errorf("必须且只能指定 tcp, http, exec, file 中的一种，检查 %s 字段", field)
+	}
+	if p.InitialDelay < 0 || p.Interval < 0 || p.Timeout < 0 {
+		return fmt.Errorf("时间不能为负数，检查 %s 字段", field)
+	}
+	if p.SuccessThreshold < 0 || p.FailureThreshold < 0 {
+		return fmt.Errorf("次数不能为负数，检查 %s 字段", field)
+	}
+	return nil
+}
+
+// check 执行一次检查，exec 探针与单元的进程一样，以 opts 指定的用户，环境变量和目录执行
+func (p Probe) check(ctx context.Context, opts ExecuteOptions) (err error) {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = DefaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch {
+	case p.TCP != "":
+		var conn net.Conn
+		if conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", p.TCP); err != nil {
+			return
+		}
+		_ = conn.Close()
+	case p.HTTP != nil:
+		var req *http.Request
+		if req, err = http.NewRequestWithContext(ctx, http.MethodGet, p.HTTP.URL, nil); err != nil {
+			return
+		}
+		var res *http.Response
+		if res, err = http.DefaultClient.Do(req); err != nil {
+			return
+		}
+		_ = res.Body.Close()
+		if p.HTTP.Status != 0 {
+			if res.StatusCode != p.HTTP.Status {
+				err = fmt.Errorf("状态码 %d，期望 %d", res.StatusCode, p.HTTP.Status)
+			}
+		} else if res.StatusCode < 200 || res.StatusCode >= 400 {
+			err = fmt.Errorf("状态码 %d", res.StatusCode)
+		}
+	case len(p.Exec) > 0:
+		err = checkProbeExec(ctx, opts, p.Exec)
+	case p.File != "":
+		_, err = os.Stat(p.File)
+	default:
+		err = errors.New("没有指定探针类型")
+	}
+	return
+}
+
+func checkProbeExec(ctx context.Context, opts ExecuteOptions, argv []string) (err error) {
+	var cred *Credential
+	if cred, err = resolveCredential(opts); err != nil {
+		return
+	}
+	var env map[string]string
+	if env, err = resolveEnviron(opts, cred); err != nil {
+		return
+	}
+	var args []string
+	if args, err = expandEnvs(argv, env); err != nil {
+		return
+	}
+	var dir string
+	if dir, err = expandEnv(opts.Dir, env); err != nil {
+		return
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = environList(env)
+	setupCmdSysProcAttr(cmd, cred)
+	if opts.Umask != "" {
+		var umask int
+		if umask, err = parseUmask(opts.Umask); err != nil {
+			return
+		}
+		setupCmdUmask(cmd, umask)
+	}
+
+	if err = startCommand(cmd); err != nil {
+		return
+	}
+	pid := cmd.Process.Pid
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			_ = signalCommand(pid, syscall.SIGKILL)
+		}
+	}()
+
+	var ws syscall.WaitStatus
+	if ws, err = waitCommand(cmd); err != nil {
+		return
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("执行超时")
+	}
+	if !ws.Exited() || ws.ExitStatus() != 0 {
+		return &ExitError{Status: ws}
+	}
+	return
+}
+
+// runProbe 周期性执行探针，连续成功达到 success_threshold 次时调用 onSuccess，连续失败达到 failure_threshold 次时调用 onFailure，
+// 回调返回 false 时停止检查，opts 为单元的命令执行选项
+func runProbe(ctx context.Context, p Probe, opts ExecuteOptions, onSuccess func() bool, onFailure func(err error) bool) {
+	interval := p.Interval
+	if interval == 0 {
+		interval = DefaultProbeInterval
+	}
+	successThreshold := p.SuccessThreshold
+	if successThreshold == 0 {
+		successThreshold = DefaultProbeSuccessThreshold
+	}
+	failureThreshold := p.FailureThreshold
+	if failureThreshold == 0 {
+		failureThreshold = DefaultProbeFailureThreshold
+	}
+
+	wait := p.InitialDelay
+
+	var successes, failures int
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		wait = interval
+
+		err := p.check(ctx, opts)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			failures = 0
+			if successes++; successes >= successThreshold {
+				successes = 0
+				if !onSuccess() {
+					return
+				}
+			}
+		} else {
+			successes = 0
+			if failures++; failures >= failureThreshold {
+				failures = 0
+				if !onFailure(err) {
+					return
+				}
+			}
+		}
+	}
+}
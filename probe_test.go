@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckProbe(t *testing.T) {
+	require.NoError(t, checkProbe(nil, "readiness"))
+	require.NoError(t, checkProbe(&Probe{TCP: "127.0.0.1:80"}, "readiness"))
+	require.Error(t, checkProbe(&Probe{}, "readiness"))
+	require.Error(t, checkProbe(&Probe{TCP: "127.0.0.1:80", File: "/tmp/ready"}, "readiness"))
+	require.Error(t, checkProbe(&Probe{HTTP: &HTTPProbe{}}, "readiness"))
+}
+
+func TestProbeCheck(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "minit-probe")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ready")
+	require.Error(t, Probe{File: file}.check(ctx, ExecuteOptions{}))
+	require.NoError(t, ioutil.WriteFile(file, []byte("ok"), 0644))
+	require.NoError(t, Probe{File: file}.check(ctx, ExecuteOptions{}))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, Probe{TCP: l.Addr().String()}.check(ctx, ExecuteOptions{}))
+	_ = l.Close()
+	require.Error(t, Probe{TCP: l.Addr().String()}.check(ctx, ExecuteOptions{}))
+
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/ok" {
+			rw.WriteHeader(http.StatusOK)
+		} else {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer s.Close()
+	require.NoError(t, Probe{HTTP: &HTTPProbe{URL: s.URL + "/ok"}}.check(ctx, ExecuteOptions{}))
+	require.Error(t, Probe{HTTP: &HTTPProbe{URL: s.URL + "/fail"}}.check(ctx, ExecuteOptions{}))
+	require.NoError(t, Probe{HTTP: &HTTPProbe{URL: s.URL + "/fail", Status: http.StatusServiceUnavailable}}.check(ctx, ExecuteOptions{}))
+
+	require.NoError(t, Probe{Exec: []string{"true"}}.check(ctx, ExecuteOptions{}))
+	require.Error(t, Probe{Exec: []string{"false"}}.check(ctx, ExecuteOptions{}))
+
+	// exec 探针使用单元的环境变量和目录
+	opts := ExecuteOptions{Dir: dir, Env: map[string]string{"PROBE_FILE": "ready"}}
+	require.NoError(t, Probe{Exec: []string{"test", "-f", "${PROBE_FILE}"}}.check(ctx, opts))
+	require.NoError(t, Probe{Exec: []string{"sh", "-c", `test "$PROBE_FILE" = ready`}}.check(ctx, opts))
+	require.Error(t, Probe{Exec: []string{"test", "-f", "${PROBE_FILE}"}}.check(ctx, ExecuteOptions{Env: map[string]string{"PROBE_FILE": "ready"}}))
+}
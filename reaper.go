@@ -0,0 +1,131 @@
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// 回收器负责对所有子进程执行 wait4(-1, ...)，包括被重新挂载到 minit 名下的孤儿进程
+// 由 minit 直接启动的进程，在启动时登记，回收后将退出状态投递给对应的等待者，避免与 exec.Cmd.Wait 竞争
+
+var (
+	reaperEnabled bool
+	reaperWaiters             = map[int]chan syscall.WaitStatus{}
+	reaperLock    sync.Locker = &sync.Mutex{}
+)
+
+// SetupReaper 启动 SIGCHLD 驱动的回收器
+func SetupReaper() (err error) {
+	if os.Getpid() != 1 {
+		// 非 PID 1 时，将 minit 设置为子进程收割者，以接管孤儿进程
+		if err = unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+			err = fmt.Errorf("无法设置子进程收割者: %s", err.Error())
+			return
+		}
+	}
+
+	chSig := make(chan os.Signal, 16)
+	signal.Notify(chSig, syscall.SIGCHLD)
+
+	reaperLock.Lock()
+	reaperEnabled = true
+	reaperLock.Unlock()
+
+	go func() {
+		for range chSig {
+			reap()
+		}
+	}()
+
+	// 回收启动前已经退出的孤儿进程
+	reap()
+
+	log.Printf("启动进程回收器")
+	return
+}
+
+func reap() {
+	// 日志在释放锁之后输出，避免写日志阻塞时影响进程的启动和等待
+	var orphans []string
+	defer func() {
+		for _, msg := range orphans {
+			log.Print(msg)
+		}
+	}()
+
+	reaperLock.Lock()
+	defer reaperLock.Unlock()
+
+	for {
+		var ws syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || pid <= 0 {
+			return
+		}
+		if ch, ok := reaperWaiters[pid]; ok {
+			delete(reaperWaiters, pid)
+			ch <- ws
+			continue
+		}
+		orphans = append(orphans, fmt.Sprintf("回收孤儿进程 %d: %s", pid, formatWaitStatus(ws)))
+	}
+}
+
+// startCommand 启动命令，如果回收器已经启动，则在同一把锁内登记进程，确保退出状态不会被当作孤儿进程回收
+func startCommand(cmd *exec.Cmd) (err error) {
+	reaperLock.Lock()
+	defer reaperLock.Unlock()
+
+	if err = cmd.Start(); err != nil {
+		return
+	}
+
+	if reaperEnabled {
+		reaperWaiters[cmd.Process.Pid] = make(chan syscall.WaitStatus, 1)
+	}
+	return
+}
+
+// signalCommand 向命令的进程组发送信号，如果进程已经被回收器回收，则不再发送，避免 PID 被重新使用后误杀其他进程组
+func signalCommand(pid int, sig syscall.Signal) error {
+	reaperLock.Lock()
+	defer reaperLock.Unlock()
+
+	if reaperEnabled {
+		if _, ok := reaperWaiters[pid]; !ok {
+			return nil
+		}
+	}
+	return signalProcessGroup(pid, sig)
+}
+
+// waitCommand 等待命令退出，返回退出状态
+func waitCommand(cmd *exec.Cmd) (ws syscall.WaitStatus, err error) {
+	reaperLock.Lock()
+	ch := reaperWaiters[cmd.Process.Pid]
+	reaperLock.Unlock()
+
+	if ch == nil {
+		// 回收器未启动，直接等待进程，输出管道由调用者关闭
+		var state *os.ProcessState
+		if state, err = cmd.Process.Wait(); err != nil {
+			return
+		}
+		ws, _ = state.Sys().(syscall.WaitStatus)
+		return
+	}
+
+	ws = <-ch
+	_ = cmd.Process.Release()
+	return
+}
@@ -0,0 +1,30 @@
+//+build !linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func SetupReaper() error {
+	return nil
+}
+
+func startCommand(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
+
+func signalCommand(pid int, sig syscall.Signal) error {
+	return signalProcessGroup(pid, sig)
+}
+
+func waitCommand(cmd *exec.Cmd) (ws syscall.WaitStatus, err error) {
+	var state *os.ProcessState
+	if state, err = cmd.Process.Wait(); err != nil {
+		return
+	}
+	ws, _ = state.Sys().(syscall.WaitStatus)
+	return
+}
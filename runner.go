@@ -3,6 +3,9 @@ package main
 import (
 	"context"
 	"github.com/guoyk93/minit/pkg/mlog"
+	"math/rand"
+	"sync"
+	"time"
 )
 
 type RunnerLevel int
@@ -44,15 +47,59 @@ var (
 				return NewCronRunner(unit, logger)
 			},
 		},
+		"timer": {
+			Level: RunnerL3,
+			Create: func(unit Unit, logger *mlog.Logger) (Runner, error) {
+				return NewTimerRunner(unit, logger)
+			},
+		},
 		"logrotate": {
 			Level: RunnerL3,
 			Create: func(unit Unit, logger *mlog.Logger) (Runner, error) {
 				return NewLogrotateRunner(unit, logger)
 			},
 		},
+		"logcollect": {
+			Level: RunnerL3,
+			Create: func(unit Unit, logger *mlog.Logger) (Runner, error) {
+				return NewLogcollectRunner(unit, logger)
+			},
+		},
 	}
 )
 
 type Runner interface {
-	Run(ctx context.Context)
+	// Run 运行单元，直到 ctx 结束，通过 UnitStatus 报告就绪和失败
+	Run(ctx context.Context, s *UnitStatus)
+}
+
+// TriggerRunner 支持手动触发的控制器，比如 cron
+type TriggerRunner interface {
+	Runner
+
+	// Trigger 立即执行一次任务，只在 Run 运行期间有效
+	Trigger() error
+}
+
+// HistoryRunner 保留执行记录的控制器，比如 cron
+type HistoryRunner interface {
+	Runner
+
+	// History 返回最近的执行记录，按照执行结束的先后顺序排列
+	History() []RunRecord
+}
+
+var (
+	randomSource                 = rand.New(rand.NewSource(time.Now().UnixNano()))
+	randomSourceLock sync.Locker = &sync.Mutex{}
+)
+
+// randomDuration 返回 [0, max) 之间的随机时长，用于错开执行时间
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	randomSourceLock.Lock()
+	defer randomSourceLock.Unlock()
+	return time.Duration(randomSource.Int63n(int64(max)))
 }
@@ -2,49 +2,259 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/guoyk93/minit/pkg/mlog"
 	"github.com/robfig/cron/v3"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
+const (
+	CronConcurrencyAllow   = "allow"
+	CronConcurrencyForbid  = "forbid"
+	CronConcurrencyReplace = "replace"
+)
+
+// cronSchedule 按照单元配置解析 cron 表达式，返回完整的表达式，时区通过 CRON_TZ 前缀指定
+func cronSchedule(unit Unit) (sched cron.Schedule, spec string, err error) {
+	options := cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor
+	if unit.CronSeconds {
+		options |= cron.Second
+	}
+	spec = strings.TrimSpace(unit.Cron)
+	if unit.Timezone != "" {
+		if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+			err = fmt.Errorf("cron 表达式中已经指定了时区，检查 timezone 字段")
+			return
+		}
+		if _, err = time.LoadLocation(unit.Timezone); err != nil {
+			err = fmt.Errorf("未知的时区 %s，检查 timezone 字段: %s", unit.Timezone, err.Error())
+			return
+		}
+		spec = "CRON_TZ=" + unit.Timezone + " " + spec
+	}
+	if sched, err = cron.NewParser(options).Parse(spec); err != nil {
+		err = fmt.Errorf("cron 表达式语法错误，检查 cron 字段: %s", err.Error())
+		return
+	}
+	return
+}
+
+// cronRun 一次正在进行的定时任务执行
+type cronRun struct {
+	cancel context.CancelFunc
+}
+
 type CronRunner struct {
 	Unit
-	logger *mlog.Logger
+	logger  *mlog.Logger
+	trigger chan struct{}
+	history *runHistory
+
+	// runs 正在进行的执行，在 l 的保护下修改，执行结束时通过 cond 通知
+	runs map[*cronRun]struct{}
+	l    *sync.Mutex
+	cond *sync.Cond
 }
 
-func (r *CronRunner) Run(ctx context.Context) {
+// acquire 按照并发策略登记一次执行，上次执行尚未结束时，forbid 跳过本次执行，replace 停止上次执行并等待其结束
+func (r *CronRunner) acquire(ctx context.Context) (run *cronRun, runCtx context.Context, ok bool) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	for len(r.runs) > 0 && r.Concurrency != CronConcurrencyAllow {
+		if r.Concurrency == CronConcurrencyForbid {
+			r.logger.Printf("上次执行尚未结束，跳过本次执行")
+			return
+		}
+		for prev := range r.runs {
+			prev.cancel()
+		}
+		r.logger.Printf("上次执行尚未结束，停止上次执行")
+		r.cond.Wait()
+	}
+
+	run = &cronRun{}
+	runCtx, run.cancel = context.WithCancel(ctx)
+	r.runs[run] = struct{}{}
+	ok = true
+	return
+}
+
+// release 移除已经结束的执行
+func (r *CronRunner) release(run *cronRun) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	run.cancel()
+	delete(r.runs, run)
+	r.cond.Broadcast()
+}
+
+func (r *CronRunner) Run(ctx context.Context, s *UnitStatus) {
 	r.logger.Printf("控制器启动")
 	defer r.logger.Printf("控制器退出")
 
-	cr := cron.New(cron.WithLogger(cron.PrintfLogger(r.logger)))
-	_, err := cr.AddFunc(r.Cron, func() {
+	// 丢弃上次运行期间未处理的手动触发
+	select {
+	case <-r.trigger:
+	default:
+	}
+
+	if err := r.history.load(); err != nil {
+		r.logger.Errorf("无法读取状态文件: %s", err.Error())
+	}
+
+	job := func(trigger string, jitter time.Duration) {
 		r.logger.Printf("定时任务触发")
-		_ = execute(r.ExecuteOptions, r.logger)
+		if delay := randomDuration(jitter); delay > 0 {
+			r.logger.Printf("随机等待 %s 后执行", delay.String())
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+		run, runCtx, ok := r.acquire(ctx)
+		if !ok {
+			r.record(RunRecord{Start: time.Now(), Trigger: trigger, Skipped: true})
+			return
+		}
+		defer r.release(run)
+
+		start := time.Now()
+		err := execute(runCtx, r.ExecuteOptions, r.logger, s.SetPID)
+		s.SetPID(0)
+
+		r.record(newRunRecord(start, trigger, err))
+
+		if err != nil {
+			if ctx.Err() != nil {
+				r.logger.Printf("定时任务被停止: %s", err.Error())
+			} else if runCtx.Err() != nil {
+				r.logger.Printf("定时任务被替换: %s", err.Error())
+			} else {
+				r.logger.Errorf("定时任务失败: %s", err.Error())
+			}
+			return
+		}
 		r.logger.Printf("定时任务结束")
-	})
+	}
+
+	sched, _, err := cronSchedule(r.Unit)
 	if err != nil {
 		// 已经检查过表达式了，不应该报错
 		panic(err)
 	}
+	cr := cron.New(cron.WithLogger(cron.PrintfLogger(r.logger)))
+	cr.Schedule(sched, cron.FuncJob(func() { job(RunTriggerSchedule, r.Jitter) }))
 
 	cr.Start()
+	s.SetReady()
+
+	// 手动触发，以及启动时执行的任务
+	wg := &sync.WaitGroup{}
 
-	<-ctx.Done()
-	<-cr.Stop().Done()
+	if r.RunOnStart {
+		r.logger.Printf("启动时执行定时任务")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			job(RunTriggerStart, 0)
+		}()
+	} else if last := r.history.lastRun(); r.CatchUp && !last.IsZero() {
+		if next := sched.Next(last); next.Before(time.Now()) {
+			r.logger.Printf("错过了 %s 的定时任务，立即执行", next.Format(time.RFC3339))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				job(RunTriggerCatchUp, 0)
+			}()
+		}
+	}
+
+	for {
+		select {
+		case <-r.trigger:
+			r.logger.Printf("手动触发定时任务")
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				job(RunTriggerManual, 0)
+			}()
+		case <-ctx.Done():
+			<-cr.Stop().Done()
+			wg.Wait()
+			return
+		}
+	}
+}
+
+// record 记录一次执行，设置了持久化时同时保存到状态文件
+func (r *CronRunner) record(rec RunRecord) {
+	if err := r.history.add(rec); err != nil {
+		r.logger.Errorf("无法保存状态文件: %s", err.Error())
+	}
+}
+
+func (r *CronRunner) History() []RunRecord {
+	return r.history.records()
+}
+
+func (r *CronRunner) Trigger() error {
+	select {
+	case r.trigger <- struct{}{}:
+		return nil
+	default:
+		return errors.New("已经有等待执行的手动触发")
+	}
 }
 
 func NewCronRunner(unit Unit, logger *mlog.Logger) (Runner, error) {
 	if len(unit.Command) == 0 {
 		return nil, fmt.Errorf("没有指定命令，检查 command 字段")
 	}
+	if err := checkExecuteOptions(unit.ExecuteOptions); err != nil {
+		return nil, err
+	}
 	if len(unit.Cron) == 0 {
 		return nil, fmt.Errorf("没有指定 cron 表达式，检查 cron 字段")
 	}
-	if _, err := cron.ParseStandard(unit.Cron); err != nil {
-		return nil, fmt.Errorf("cron 表达式语法错误，检查 cron 字段: %s", err.Error())
+	if _, _, err := cronSchedule(unit); err != nil {
+		return nil, err
+	}
+	if unit.Jitter < 0 {
+		return nil, fmt.Errorf("随机等待时间不能为负数，检查 jitter 字段")
+	}
+	switch unit.Concurrency {
+	case "":
+		unit.Concurrency = CronConcurrencyAllow
+	case CronConcurrencyAllow, CronConcurrencyForbid, CronConcurrencyReplace:
+	default:
+		return nil, fmt.Errorf("未知的并发策略 %s，检查 concurrency 字段", unit.Concurrency)
+	}
+	if unit.HistoryLimit < 0 {
+		return nil, fmt.Errorf("执行记录数量不能为负数，检查 history_limit 字段")
+	}
+	if unit.HistoryLimit == 0 {
+		unit.HistoryLimit = DefaultHistoryLimit
+	}
+	var historyPath string
+	if unit.HistoryPersist || unit.CatchUp {
+		historyPath = filepath.Join(optLogDir, unit.Name+".cron.json")
 	}
+	l := &sync.Mutex{}
 	return &CronRunner{
-		Unit:   unit,
-		logger: logger,
+		Unit:    unit,
+		logger:  logger,
+		trigger: make(chan struct{}, 1),
+		history: newRunHistory(historyPath, unit.HistoryLimit),
+		runs:    map[*cronRun]struct{}{},
+		l:       l,
+		cond:    sync.NewCond(l),
 	}, nil
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewCronRunner(t *testing.T) {
+	_, err := NewCronRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}}, Cron: "* * * * *", Concurrency: "queue"}, nil)
+	require.Error(t, err)
+	r, err := NewCronRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}}, Cron: "* * * * *"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, CronConcurrencyAllow, r.(*CronRunner).Concurrency)
+}
+
+func TestCronSchedule(t *testing.T) {
+	_, _, err := cronSchedule(Unit{Cron: "*/5 * * * * *"})
+	require.Error(t, err)
+	sched, _, err := cronSchedule(Unit{Cron: "*/5 * * * * *", CronSeconds: true})
+	require.NoError(t, err)
+	now := time.Date(2020, 1, 1, 0, 0, 1, 0, time.UTC)
+	require.Equal(t, now.Add(4*time.Second), sched.Next(now))
+
+	_, _, err = cronSchedule(Unit{Cron: "0 9 * * *", Timezone: "Mars/Olympus"})
+	require.Error(t, err)
+	_, _, err = cronSchedule(Unit{Cron: "CRON_TZ=UTC 0 9 * * *", Timezone: "Asia/Shanghai"})
+	require.Error(t, err)
+	sched, spec, err := cronSchedule(Unit{Cron: "0 9 * * *", Timezone: "Asia/Shanghai"})
+	require.NoError(t, err)
+	require.Equal(t, "CRON_TZ=Asia/Shanghai 0 9 * * *", spec)
+	require.Equal(t, 1, sched.Next(now).UTC().Hour())
+
+	_, err = NewCronRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}}, Cron: "* * * * *", Jitter: -time.Second}, nil)
+	require.Error(t, err)
+}
+
+func TestCronRunnerConcurrency(t *testing.T) {
+	logger, _ := newTestLogger(t)
+
+	newRunner := func(concurrency string) *CronRunner {
+		r, err := NewCronRunner(Unit{
+			ExecuteOptions: ExecuteOptions{Command: []string{"true"}},
+			Cron:           "* * * * *",
+			Concurrency:    concurrency,
+		}, logger)
+		require.NoError(t, err)
+		return r.(*CronRunner)
+	}
+
+	ctx := context.Background()
+
+	r := newRunner(CronConcurrencyAllow)
+	_, _, ok := r.acquire(ctx)
+	require.True(t, ok)
+	_, _, ok = r.acquire(ctx)
+	require.True(t, ok)
+	require.Len(t, r.runs, 2)
+
+	r = newRunner(CronConcurrencyForbid)
+	run, _, ok := r.acquire(ctx)
+	require.True(t, ok)
+	_, _, ok = r.acquire(ctx)
+	require.False(t, ok)
+	r.release(run)
+	_, _, ok = r.acquire(ctx)
+	require.True(t, ok)
+
+	r = newRunner(CronConcurrencyReplace)
+	run, runCtx, ok := r.acquire(ctx)
+	require.True(t, ok)
+	go func() {
+		<-runCtx.Done()
+		r.release(run)
+	}()
+	_, _, ok = r.acquire(ctx)
+	require.True(t, ok)
+	require.Error(t, runCtx.Err())
+	require.Len(t, r.runs, 1)
+}
+
+func TestRunHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minit-cron-history")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.cron.json")
+	h := newRunHistory(path, 2)
+	require.NoError(t, h.load())
+	require.True(t, h.lastRun().IsZero())
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, h.add(RunRecord{Start: start, Trigger: RunTriggerSchedule}))
+	require.NoError(t, h.add(RunRecord{Start: start.Add(time.Hour), Trigger: RunTriggerSchedule, ExitCode: 1}))
+	require.NoError(t, h.add(RunRecord{Start: start.Add(2 * time.Hour), Trigger: RunTriggerSchedule, Skipped: true}))
+
+	h = newRunHistory(path, 2)
+	require.NoError(t, h.load())
+	records := h.records()
+	require.Len(t, records, 2)
+	require.Equal(t, 1, records[0].ExitCode)
+	require.True(t, records[1].Skipped)
+	require.True(t, h.lastRun().Equal(start.Add(time.Hour)))
+}
+
+func TestCronRunnerCatchUp(t *testing.T) {
+	logger, dir := newTestLogger(t)
+
+	logDir := optLogDir
+	optLogDir = dir
+	defer func() {
+		optLogDir = logDir
+	}()
+
+	last := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, newRunHistory(filepath.Join(dir, "catch-up.cron.json"), 1).add(RunRecord{Start: last}))
+
+	r, err := NewCronRunner(Unit{
+		Name:           "catch-up",
+		ExecuteOptions: ExecuteOptions{Command: []string{"true"}},
+		Cron:           "0 0 * * *",
+		CatchUp:        true,
+	}, logger)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(ctx, NewUnitStatus())
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(r.(HistoryRunner).History()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	records := r.(HistoryRunner).History()
+	require.Equal(t, RunTriggerCatchUp, records[1].Trigger)
+	require.Equal(t, 0, records[1].ExitCode)
+}
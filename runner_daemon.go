@@ -2,19 +2,89 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/guoyk93/minit/pkg/mlog"
 	"time"
 )
 
+const (
+	RestartAlways    = "always"
+	RestartOnFailure = "on-failure"
+	RestartNever     = "never"
+
+	DefaultRestartDelay      = time.Second * 5
+	DefaultRestartResetAfter = time.Minute
+)
+
+// restartBackoff 计算重启等待时间，按指数增长，并在进程健康运行一段时间后重置，resetAfter 为 0 时不重置
+type restartBackoff struct {
+	initial    time.Duration
+	max        time.Duration
+	resetAfter time.Duration
+
+	current time.Duration
+}
+
+func (b *restartBackoff) next(ran time.Duration) time.Duration {
+	if b.current == 0 || (b.resetAfter > 0 && ran >= b.resetAfter) {
+		b.current = b.initial
+	}
+	delay := b.current
+	b.current = b.current * 2
+	if b.current > b.max {
+		b.current = b.max
+	}
+	return delay
+}
+
+// restartBudget 限制时间窗口内的重启次数，窗口为 0 时表示不限时间
+type restartBudget struct {
+	max    int
+	window time.Duration
+
+	history []time.Time
+}
+
+func (b *restartBudget) take(now time.Time) bool {
+	if b.max <= 0 {
+		return true
+	}
+	if b.window > 0 {
+		var history []time.Time
+		for _, t := range b.history {
+			if now.Sub(t) < b.window {
+				history = append(history, t)
+			}
+		}
+		b.history = history
+	}
+	if len(b.history) >= b.max {
+		return false
+	}
+	b.history = append(b.history, now)
+	return true
+}
+
 type DaemonRunner struct {
 	Unit
 	logger *mlog.Logger
 }
 
-func (r *DaemonRunner) Run(ctx context.Context) {
+func (r *DaemonRunner) Run(ctx context.Context, s *UnitStatus) {
 	r.logger.Printf("控制器启动")
 	defer r.logger.Printf("控制器退出")
+
+	backoff := &restartBackoff{
+		initial:    r.RestartDelay,
+		max:        r.RestartMaxDelay,
+		resetAfter: r.RestartResetAfter,
+	}
+	budget := &restartBudget{
+		max:    r.MaxRestarts,
+		window: r.MaxRestartsWindow,
+	}
+
 forLoop:
 	for {
 		// 检查 ctx 是否已经结束
@@ -22,9 +92,30 @@ forLoop:
 			break forLoop
 		}
 
+		startedAt := time.Now()
+
+		runCtx, runCancel := context.WithCancel(ctx)
+		chUnhealthy := make(chan error, 1)
+
 		var err error
-		if err = execute(r.ExecuteOptions, r.logger); err != nil {
-			r.logger.Errorf("启动失败: %s", err.Error())
+		if err = execute(runCtx, r.ExecuteOptions, r.logger, func(pid int) {
+			s.SetPID(pid)
+			r.checkReadiness(runCtx, s)
+			r.checkLiveness(runCtx, runCancel, chUnhealthy)
+		}); err != nil {
+			if _, ok := err.(*ExitError); !ok {
+				r.logger.Errorf("启动失败: %s", err.Error())
+			}
+		}
+
+		runCancel()
+		s.SetPID(0)
+
+		// 因为存活检查失败而停止的进程，视为失败
+		select {
+		case uerr := <-chUnhealthy:
+			err = fmt.Errorf("存活检查失败: %s", uerr.Error())
+		default:
 		}
 
 		// 检查 ctx 是否已经结束
@@ -32,22 +123,135 @@ forLoop:
 			break forLoop
 		}
 
+		// 重启策略
+		switch r.Restart {
+		case RestartNever:
+			r.logger.Printf("重启策略为 %s，不再重启", r.Restart)
+			if err != nil {
+				s.SetFailed(err)
+			}
+			break forLoop
+		case RestartOnFailure:
+			if err == nil {
+				r.logger.Printf("进程正常退出，重启策略为 %s，不再重启", r.Restart)
+				break forLoop
+			}
+		}
+
+		// 重启次数限制
+		if !budget.take(time.Now()) {
+			var limit string
+			if r.MaxRestartsWindow > 0 {
+				limit = fmt.Sprintf("%s 内重启次数超过 %d 次", r.MaxRestartsWindow.String(), r.MaxRestarts)
+			} else {
+				limit = fmt.Sprintf("重启次数超过 %d 次", r.MaxRestarts)
+			}
+			// 保留最后一次的退出状态，用于关键单元的退出码
+			if err != nil {
+				err = fmt.Errorf("%s，最后一次%w", limit, err)
+			} else {
+				err = errors.New(limit)
+			}
+			r.logger.Errorf("%s，单元失败", err.Error())
+			s.SetFailed(err)
+			break forLoop
+		}
+
 		// 重试
-		r.logger.Printf("5s 后重启")
+		delay := backoff.next(time.Since(startedAt))
+		delay = (delay + randomDuration(delay/5)).Truncate(time.Millisecond)
+		r.logger.Printf("%s 后重启", delay.String())
 
-		timer := time.NewTimer(time.Second * 5)
+		timer := time.NewTimer(delay)
 		select {
 		case <-timer.C:
 		case <-ctx.Done():
+			timer.Stop()
 			break forLoop
 		}
+		s.AddRestart()
+	}
+}
+
+// checkReadiness 进程启动后，执行就绪探针，未指定就绪探针时，直接标记就绪
+func (r *DaemonRunner) checkReadiness(ctx context.Context, s *UnitStatus) {
+	select {
+	case <-s.Ready():
+		return
+	default:
+	}
+	if r.Readiness == nil {
+		s.SetReady()
+		return
+	}
+	go runProbe(ctx, *r.Readiness, r.ExecuteOptions, func() bool {
+		r.logger.Printf("就绪检查通过")
+		s.SetReady()
+		return false
+	}, func(err error) bool {
+		r.logger.Errorf("就绪检查失败: %s", err.Error())
+		return true
+	})
+}
+
+// checkLiveness 进程启动后，执行存活探针，连续失败后，通过 stop 停止进程
+func (r *DaemonRunner) checkLiveness(ctx context.Context, stop context.CancelFunc, chUnhealthy chan error) {
+	if r.Liveness == nil {
+		return
 	}
+	go runProbe(ctx, *r.Liveness, r.ExecuteOptions, func() bool {
+		return true
+	}, func(err error) bool {
+		r.logger.Errorf("存活检查失败，停止进程: %s", err.Error())
+		chUnhealthy <- err
+		stop()
+		return false
+	})
 }
 
 func NewDaemonRunner(unit Unit, logger *mlog.Logger) (Runner, error) {
 	if len(unit.Command) == 0 {
 		return nil, fmt.Errorf("没有指定命令，检查 command 字段")
 	}
+	if err := checkExecuteOptions(unit.ExecuteOptions); err != nil {
+		return nil, err
+	}
+	if err := checkProbe(unit.Readiness, "readiness"); err != nil {
+		return nil, err
+	}
+	if err := checkProbe(unit.Liveness, "liveness"); err != nil {
+		return nil, err
+	}
+	switch unit.Restart {
+	case "":
+		// 关键单元默认不重启，进程退出即视为单元结束
+		if unit.Critical {
+			unit.Restart = RestartNever
+		} else {
+			unit.Restart = RestartAlways
+		}
+	case RestartAlways, RestartOnFailure, RestartNever:
+	default:
+		return nil, fmt.Errorf("未知的重启策略 %s，检查 restart 字段", unit.Restart)
+	}
+	if unit.RestartDelay < 0 || unit.RestartMaxDelay < 0 || unit.RestartResetAfter < 0 || unit.MaxRestartsWindow < 0 {
+		return nil, fmt.Errorf("时间不能为负数，检查 restart_delay, restart_max_delay, restart_reset_after, max_restarts_window 字段")
+	}
+	if unit.MaxRestarts < 0 {
+		return nil, fmt.Errorf("重启次数不能为负数，检查 max_restarts 字段")
+	}
+	if unit.RestartDelay == 0 {
+		unit.RestartDelay = DefaultRestartDelay
+	}
+	if unit.RestartMaxDelay == 0 {
+		unit.RestartMaxDelay = unit.RestartDelay
+	}
+	if unit.RestartMaxDelay < unit.RestartDelay {
+		return nil, fmt.Errorf("最大重启等待时间不能小于初始重启等待时间，检查 restart_max_delay 字段")
+	}
+	if unit.RestartResetAfter == 0 {
+		unit.RestartResetAfter = DefaultRestartResetAfter
+	}
 	return &DaemonRunner{
 		Unit:   unit,
 		logger: logger,
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	b := &restartBackoff{initial: time.Second, max: time.Second * 5, resetAfter: time.Minute}
+	require.Equal(t, time.Second, b.next(0))
+	require.Equal(t, time.Second*2, b.next(0))
+	require.Equal(t, time.Second*4, b.next(0))
+	require.Equal(t, time.Second*5, b.next(0))
+	require.Equal(t, time.Second*5, b.next(0))
+	require.Equal(t, time.Second, b.next(time.Minute))
+	require.Equal(t, time.Second*2, b.next(0))
+}
+
+func TestRestartBudget(t *testing.T) {
+	now := time.Now()
+	b := &restartBudget{max: 2, window: time.Minute}
+	require.True(t, b.take(now))
+	require.True(t, b.take(now.Add(time.Second)))
+	require.False(t, b.take(now.Add(time.Second*2)))
+	require.True(t, b.take(now.Add(time.Minute+time.Second)))
+
+	b = &restartBudget{max: 1}
+	require.True(t, b.take(now))
+	require.False(t, b.take(now.Add(time.Hour)))
+
+	b = &restartBudget{}
+	require.True(t, b.take(now))
+}
+
+func TestNewDaemonRunner(t *testing.T) {
+	_, err := NewDaemonRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}}, Restart: "sometimes"}, nil)
+	require.Error(t, err)
+	_, err = NewDaemonRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}}, RestartDelay: time.Minute, RestartMaxDelay: time.Second}, nil)
+	require.Error(t, err)
+	r, err := NewDaemonRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, RestartAlways, r.(*DaemonRunner).Restart)
+	require.Equal(t, DefaultRestartDelay, r.(*DaemonRunner).RestartDelay)
+	require.Equal(t, DefaultRestartDelay, r.(*DaemonRunner).RestartMaxDelay)
+	r, err = NewDaemonRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}}, Critical: true}, nil)
+	require.NoError(t, err)
+	require.Equal(t, RestartNever, r.(*DaemonRunner).Restart)
+}
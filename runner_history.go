@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHistoryLimit = 10
+
+	RunTriggerSchedule = "schedule" // 按照 cron 表达式或者 interval 执行
+	RunTriggerManual   = "manual"   // 手动触发
+	RunTriggerStart    = "start"    // run_on_start，启动时执行
+	RunTriggerCatchUp  = "catch_up" // catch_up，补充执行停机期间错过的任务
+)
+
+// RunRecord 一次执行的记录
+type RunRecord struct {
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Trigger  string        `json:"trigger"`
+	ExitCode int           `json:"exit_code"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// newRunRecord 按照 execute 的结果创建执行记录
+func newRunRecord(start time.Time, trigger string, err error) RunRecord {
+	rec := RunRecord{Start: start, Duration: time.Since(start), Trigger: trigger, ExitCode: exitCodeOf(err)}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	return rec
+}
+
+// runState 持久化的定时任务状态
+type runState struct {
+	LastRun time.Time   `json:"last_run"`
+	History []RunRecord `json:"history"`
+}
+
+// runHistory cron, timer 单元的执行记录，保留最近 limit 条，path 不为空时持久化到文件
+type runHistory struct {
+	path  string
+	limit int
+	state runState
+
+	l sync.Locker
+}
+
+func newRunHistory(path string, limit int) *runHistory {
+	return &runHistory{
+		path:  path,
+		limit: limit,
+		l:     &sync.Mutex{},
+	}
+}
+
+// load 载入持久化的状态，状态文件不存在时不报错
+func (h *runHistory) load() (err error) {
+	if h.path == "" {
+		return
+	}
+	var buf []byte
+	if buf, err = ioutil.ReadFile(h.path); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	var state runState
+	if err = json.Unmarshal(buf, &state); err != nil {
+		return
+	}
+
+	h.l.Lock()
+	defer h.l.Unlock()
+	h.state = state
+	h.trim()
+	return
+}
+
+// save 保存状态，调用时必须持有 l
+func (h *runHistory) save() (err error) {
+	if h.path == "" {
+		return
+	}
+	var buf []byte
+	if buf, err = json.Marshal(h.state); err != nil {
+		return
+	}
+	// 先写入临时文件再重命名，避免写入中断导致状态文件损坏
+	if err = ioutil.WriteFile(h.path+".tmp", buf, 0644); err != nil {
+		return
+	}
+	return os.Rename(h.path+".tmp", h.path)
+}
+
+// trim 只保留最近 limit 条记录，调用时必须持有 l
+func (h *runHistory) trim() {
+	if len(h.state.History) > h.limit {
+		h.state.History = append([]RunRecord{}, h.state.History[len(h.state.History)-h.limit:]...)
+	}
+}
+
+// add 添加一条记录，没有跳过的记录同时更新最后一次执行的时间
+func (h *runHistory) add(rec RunRecord) error {
+	h.l.Lock()
+	defer h.l.Unlock()
+	h.state.History = append(h.state.History, rec)
+	h.trim()
+	if !rec.Skipped && rec.Start.After(h.state.LastRun) {
+		h.state.LastRun = rec.Start
+	}
+	return h.save()
+}
+
+// records 返回所有记录，按照执行结束的先后顺序排列
+func (h *runHistory) records() []RunRecord {
+	h.l.Lock()
+	defer h.l.Unlock()
+	return append([]RunRecord{}, h.state.History...)
+}
+
+// lastRun 返回最后一次执行的时间，没有执行过时为零值
+func (h *runHistory) lastRun() time.Time {
+	h.l.Lock()
+	defer h.l.Unlock()
+	return h.state.LastRun
+}
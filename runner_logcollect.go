@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/guoyk93/minit/pkg/mlog"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultLogcollectInterval = time.Second
+
+	// LogcollectDetachGrace 文件被重命名或者删除后，继续读取的时间，用于读取进程重新打开文件前写入的内容
+	LogcollectDetachGrace = time.Second * 5
+	// LogcollectMaxLineSize 单行最大长度，超过后直接输出
+	LogcollectMaxLineSize = 64 * 1024
+
+	logcollectReadSize = 32 * 1024
+)
+
+type logcollectKey struct {
+	Dev uint64
+	Ino uint64
+}
+
+// logcollectOffset 持久化的读取位置
+type logcollectOffset struct {
+	Path   string `json:"path"`
+	Dev    uint64 `json:"dev"`
+	Ino    uint64 `json:"ino"`
+	Offset int64  `json:"offset"`
+}
+
+type logcollectFile struct {
+	key    logcollectKey
+	path   string
+	file   *os.File
+	offset int64  // 已经输出的完整行的位置
+	buf    []byte // 尚未结束的行
+
+	detachedAt time.Time // 文件不再匹配任何通配符的时间
+	grownAt    time.Time // 最后一次读取到内容的时间
+}
+
+type LogcollectRunner struct {
+	Unit
+	logger *mlog.Logger
+
+	files map[logcollectKey]*logcollectFile
+	dirty bool
+}
+
+func (r *LogcollectRunner) statePath() string {
+	return filepath.Join(optLogDir, r.Name+".logcollect.json")
+}
+
+// loadState 载入持久化的读取位置，existed 表示是否存在状态文件
+func (r *LogcollectRunner) loadState() (offsets map[logcollectKey]logcollectOffset, existed bool) {
+	offsets = map[logcollectKey]logcollectOffset{}
+	buf, err := ioutil.ReadFile(r.statePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			r.logger.Errorf("无法读取状态文件: %s", err.Error())
+		}
+		return
+	}
+	existed = true
+	var items []logcollectOffset
+	if err = json.Unmarshal(buf, &items); err != nil {
+		r.logger.Errorf("无法解析状态文件: %s", err.Error())
+		return
+	}
+	for _, item := range items {
+		offsets[logcollectKey{Dev: item.Dev, Ino: item.Ino}] = item
+	}
+	return
+}
+
+func (r *LogcollectRunner) saveState() {
+	if !r.dirty {
+		return
+	}
+	items := make([]logcollectOffset, 0, len(r.files))
+	for _, lf := range r.files {
+		items = append(items, logcollectOffset{Path: lf.path, Dev: lf.key.Dev, Ino: lf.key.Ino, Offset: lf.offset})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Path < items[j].Path
+	})
+	buf, err := json.Marshal(items)
+	if err != nil {
+		r.logger.Errorf("无法保存状态文件: %s", err.Error())
+		return
+	}
+	// 先写入临时文件再重命名，避免写入中断导致状态文件损坏
+	name := r.statePath()
+	if err = ioutil.WriteFile(name+".tmp", buf, 0644); err == nil {
+		err = os.Rename(name+".tmp", name)
+	}
+	if err != nil {
+		r.logger.Errorf("无法保存状态文件: %s", err.Error())
+		return
+	}
+	r.dirty = false
+}
+
+// scan 查找匹配通配符的文件
+func (r *LogcollectRunner) scan() (found map[logcollectKey]string) {
+	found = map[logcollectKey]string{}
+
+	env, err := resolveEnviron(r.ExecuteOptions, nil)
+	var patterns []string
+	if err == nil {
+		patterns, err = expandEnvs(r.Files, env)
+	}
+	if err != nil {
+		r.logger.Errorf("无法展开 files 字段: %s", err.Error())
+		return
+	}
+
+	// 跳过 minit 自身的日志目录，避免重复输出，甚至循环读取自身的输出
+	logDir, _ := filepath.Abs(optLogDir)
+
+	for _, pattern := range patterns {
+		names, err := filepath.Glob(pattern)
+		if err != nil {
+			r.logger.Errorf("匹配表达式 %s 格式错误: %s", pattern, err.Error())
+			continue
+		}
+		for _, name := range names {
+			if abs, _ := filepath.Abs(name); filepath.Dir(abs) == logDir {
+				continue
+			}
+			fi, err := os.Stat(name)
+			if err != nil || !fi.Mode().IsRegular() {
+				continue
+			}
+			dev, ino := fileID(name, fi)
+			found[logcollectKey{Dev: dev, Ino: ino}] = name
+		}
+	}
+	return
+}
+
+// attach 打开新出现的文件，首次扫描且没有状态文件时，从文件末尾开始读取，否则从保存的位置或者文件开头开始读取
+func (r *LogcollectRunner) attach(key logcollectKey, name string, offsets map[logcollectKey]logcollectOffset, fromEnd bool) {
+	f, err := os.Open(name)
+	if err != nil {
+		r.logger.Errorf("无法打开文件 %s: %s", name, err.Error())
+		return
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		r.logger.Errorf("无法打开文件 %s: %s", name, err.Error())
+		return
+	}
+	// 打开文件期间，路径可能已经指向另一个文件
+	if dev, ino := fileID(name, fi); dev != key.Dev || ino != key.Ino {
+		_ = f.Close()
+		return
+	}
+
+	var offset int64
+	if saved, ok := offsets[key]; ok {
+		offset = saved.Offset
+	} else if fromEnd {
+		offset = fi.Size()
+	}
+	if offset > fi.Size() {
+		offset = 0
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		r.logger.Errorf("无法定位文件 %s: %s", name, err.Error())
+		return
+	}
+
+	r.files[key] = &logcollectFile{
+		key:     key,
+		path:    name,
+		file:    f,
+		offset:  offset,
+		grownAt: time.Now(),
+	}
+	r.dirty = true
+}
+
+// read 读取文件新增的内容，按行输出，返回是否读取到内容
+func (r *LogcollectRunner) read(lf *logcollectFile) (grown bool) {
+	// 文件被截断，比如 copytruncate，从头开始读取
+	if fi, err := lf.file.Stat(); err == nil && fi.Size() < lf.offset+int64(len(lf.buf)) {
+		r.logger.Printf("文件被截断，从头读取: %s", lf.path)
+		if _, err = lf.file.Seek(0, io.SeekStart); err == nil {
+			lf.offset, lf.buf = 0, nil
+			r.dirty = true
+		}
+	}
+
+	chunk := make([]byte, logcollectReadSize)
+	for {
+		n, err := lf.file.Read(chunk)
+		if n > 0 {
+			grown = true
+			lf.buf = append(lf.buf, chunk[:n]...)
+			r.emit(lf, false)
+		}
+		if err != nil || n == 0 {
+			return
+		}
+	}
+}
+
+// emit 输出缓冲区中的完整行，flush 为 true 时，同时输出未结束的行
+func (r *LogcollectRunner) emit(lf *logcollectFile, flush bool) {
+	for {
+		idx := bytes.IndexByte(lf.buf, '\n')
+		if idx < 0 {
+			if len(lf.buf) == 0 || (!flush && len(lf.buf) < LogcollectMaxLineSize) {
+				return
+			}
+			idx = len(lf.buf)
+		}
+		r.logger.WriteOut(string(bytes.TrimRight(lf.buf[:idx], "\r")))
+		if idx < len(lf.buf) {
+			idx++
+		}
+		lf.offset += int64(idx)
+		lf.buf = lf.buf[idx:]
+		r.dirty = true
+	}
+}
+
+func (r *LogcollectRunner) close(lf *logcollectFile) {
+	r.emit(lf, true)
+	_ = lf.file.Close()
+	delete(r.files, lf.key)
+	r.dirty = true
+}
+
+func (r *LogcollectRunner) poll(offsets map[logcollectKey]logcollectOffset, fromEnd bool) {
+	now := time.Now()
+	found := r.scan()
+
+	for key, name := range found {
+		if lf := r.files[key]; lf != nil {
+			// 文件被重命名，比如 logrotate 单元产生的 .ROT 文件，继续跟踪
+			if lf.path != name {
+				lf.path = name
+				r.dirty = true
+			}
+			lf.detachedAt = time.Time{}
+			continue
+		}
+		r.attach(key, name, offsets, fromEnd)
+	}
+
+	for key, lf := range r.files {
+		if _, ok := found[key]; !ok && lf.detachedAt.IsZero() {
+			lf.detachedAt = now
+		}
+		if r.read(lf) {
+			lf.grownAt = now
+		}
+		// 文件不再匹配任何通配符，并且一段时间内没有新的内容，停止跟踪
+		if !lf.detachedAt.IsZero() && now.Sub(lf.detachedAt) >= LogcollectDetachGrace && now.Sub(lf.grownAt) >= LogcollectDetachGrace {
+			r.close(lf)
+		}
+	}
+
+	r.saveState()
+}
+
+func (r *LogcollectRunner) Run(ctx context.Context, s *UnitStatus) {
+	r.logger.Printf("控制器启动")
+	defer r.logger.Printf("控制器退出")
+
+	r.files = map[logcollectKey]*logcollectFile{}
+	defer func() {
+		for _, lf := range r.files {
+			_ = lf.file.Close()
+		}
+		r.files = nil
+	}()
+
+	// 首次扫描时，没有状态文件的情况下，从文件末尾开始读取，避免输出大量历史日志
+	offsets, existed := r.loadState()
+	r.poll(offsets, !existed)
+	s.SetReady()
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.poll(nil, false)
+		case <-ctx.Done():
+			r.poll(nil, false)
+			return
+		}
+	}
+}
+
+func NewLogcollectRunner(unit Unit, logger *mlog.Logger) (Runner, error) {
+	if len(unit.Files) == 0 {
+		return nil, fmt.Errorf("没有指定文件，检查 files 字段")
+	}
+	if err := checkExpandFiles(unit.ExecuteOptions, unit.Files); err != nil {
+		return nil, err
+	}
+	if unit.Interval < 0 {
+		return nil, fmt.Errorf("时间不能为负数，检查 interval 字段")
+	}
+	if unit.Interval == 0 {
+		unit.Interval = DefaultLogcollectInterval
+	}
+	return &LogcollectRunner{
+		Unit:   unit,
+		logger: logger,
+	}, nil
+}
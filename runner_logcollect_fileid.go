@@ -0,0 +1,16 @@
+//+build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID 返回文件的设备号和 inode，用于在文件重命名后继续跟踪
+func fileID(name string, fi os.FileInfo) (dev uint64, ino uint64) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev), st.Ino
+	}
+	return 0, 0
+}
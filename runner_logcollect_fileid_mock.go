@@ -0,0 +1,14 @@
+//+build !linux
+
+package main
+
+import (
+	"hash/fnv"
+	"os"
+)
+
+func fileID(name string, fi os.FileInfo) (dev uint64, ino uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return 0, h.Sum64()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/guoyk93/minit/pkg/mlog"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func logcollectLines(logger *mlog.Logger) (out []string) {
+	for _, line := range logger.Recent(-1) {
+		s := strings.TrimSpace(string(line))
+		out = append(out, s[strings.Index(s, "] ")+2:])
+	}
+	return
+}
+
+func TestLogcollectRunner(t *testing.T) {
+	logger, dir := newTestLogger(t)
+
+	defer func(v string) { optLogDir = v }(optLogDir)
+	optLogDir = dir
+
+	appDir := filepath.Join(dir, "app")
+	require.NoError(t, os.MkdirAll(appDir, 0755))
+	name := filepath.Join(appDir, "app.log")
+	require.NoError(t, ioutil.WriteFile(name, []byte("history\n"), 0644))
+
+	// 日志目录中的文件会被跳过
+	r, err := NewLogcollectRunner(Unit{Name: "test-logcollect", Files: []string{filepath.Join(dir, "*.log"), filepath.Join(appDir, "*.log")}}, logger)
+	require.NoError(t, err)
+	lr := r.(*LogcollectRunner)
+
+	// 没有状态文件时，从末尾开始读取
+	lr.files = map[logcollectKey]*logcollectFile{}
+	offsets, existed := lr.loadState()
+	require.False(t, existed)
+	lr.poll(offsets, true)
+
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, _ = f.WriteString("line1\npart")
+	lr.poll(nil, false)
+	require.Equal(t, []string{"line1"}, logcollectLines(logger))
+
+	// 重命名后继续读取原文件
+	require.NoError(t, os.Rename(name, filepath.Join(appDir, "app.ROT2020-01-01.log")))
+	_, _ = f.WriteString("ial\n")
+	_ = f.Close()
+	require.NoError(t, ioutil.WriteFile(name, []byte("new\n"), 0644))
+	lr.poll(nil, false)
+	lines := logcollectLines(logger)
+	require.Len(t, lines, 3)
+	require.ElementsMatch(t, []string{"partial", "new"}, lines[1:])
+
+	for _, lf := range lr.files {
+		_ = lf.file.Close()
+	}
+
+	// 重新启动后，从保存的位置继续读取
+	require.NoError(t, ioutil.WriteFile(name, []byte("new\nnext\n"), 0644))
+	lr.files = map[logcollectKey]*logcollectFile{}
+	offsets, existed = lr.loadState()
+	require.True(t, existed)
+	lr.poll(offsets, true)
+	lines = logcollectLines(logger)
+	require.Equal(t, "next", lines[len(lines)-1])
+	require.Len(t, lines, 4)
+}
@@ -16,23 +16,38 @@ import (
 
 // filename mark
 // daily: FILENAME.ROT2020-06-02.EXT
+// hourly: FILENAME.ROT2020-06-02-15.EXT
 // filesize: FILENAME.ROT000000000001.EXT (%012d)
+// daily-or-filesize: FILENAME.ROT2020-06-02-000001.EXT (%06d)
 
 const (
-	RotationModeDaily    = "daily"
-	RotationModeFilesize = "filesize"
+	RotationModeDaily         = "daily"
+	RotationModeHourly        = "hourly"
+	RotationModeFilesize      = "filesize"
+	RotationModeDailyFilesize = "daily-or-filesize"
 
 	RotationCron = "@every 1m"
 
-	RotationDailyDateLayout = "2006-01-02"
-	RotationFilesize        = 256 * 1024 * 1024
+	RotationDailyDateLayout  = "2006-01-02"
+	RotationHourlyDateLayout = "2006-01-02-15"
+	RotationFilesize         = 256 * 1024 * 1024
 
 	Rot = "ROT"
 )
 
 var (
-	RotationMarkDailyPattern    = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
-	RotationMarkFilesizePattern = regexp.MustCompile(`^\d+$`)
+	RotationMarkDailyPattern         = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	RotationMarkHourlyPattern        = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}-\d{2}$`)
+	RotationMarkFilesizePattern      = regexp.MustCompile(`^\d+$`)
+	RotationMarkDailyFilesizePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}-\d{6}$`)
+
+	// rotationMarkPatterns 各个模式的 ROT 标记，不符合的 ROT 文件会被删除
+	rotationMarkPatterns = map[string]*regexp.Regexp{
+		RotationModeDaily:         RotationMarkDailyPattern,
+		RotationModeHourly:        RotationMarkHourlyPattern,
+		RotationModeFilesize:      RotationMarkFilesizePattern,
+		RotationModeDailyFilesize: RotationMarkDailyFilesizePattern,
+	}
 )
 
 func rotationMarkExtract(filename string) (original string, mark string) {
@@ -68,23 +83,37 @@ type rotationFile struct {
 type LogrotateRunner struct {
 	Unit
 	logger *mlog.Logger
+
+	// lastCheck 上次检查的时间，用于 daily-or-filesize 模式判断是否跨越了日期
+	lastCheck time.Time
+	// now 返回当前时间，测试中可以替换
+	now func() time.Time
 }
 
-func (l *LogrotateRunner) Run(ctx context.Context) {
+func (l *LogrotateRunner) Run(ctx context.Context, s *UnitStatus) {
 	l.logger.Printf("控制器启动")
 	defer l.logger.Printf("控制器退出")
 
-	cr := cron.New(cron.WithLogger(cron.PrintfLogger(l.logger)))
-	_, err := cr.AddFunc(RotationCron, func() {
-		l.logger.Printf("开始日志轮转")
-		defer l.logger.Printf("结束日志轮转")
-		l.rotate()
-	})
+	sched, _, err := cronSchedule(l.Unit)
 	if err != nil {
+		// 已经检查过表达式了，不应该报错
 		panic(err)
 	}
 
+	// 上次轮转尚未结束时，跳过本次轮转
+	cr := cron.New(
+		cron.WithLogger(cron.PrintfLogger(l.logger)),
+		cron.WithChain(cron.SkipIfStillRunning(cron.PrintfLogger(l.logger))),
+	)
+	cr.Schedule(sched, cron.FuncJob(func() {
+		l.logger.Printf("开始日志轮转")
+		defer l.logger.Printf("结束日志轮转")
+		l.rotate(ctx)
+	}))
+
 	cr.Start()
+	s.SetReady()
+
 	<-ctx.Done()
 	<-cr.Stop().Done()
 }
@@ -92,7 +121,17 @@ func (l *LogrotateRunner) Run(ctx context.Context) {
 func (l *LogrotateRunner) collectRotationFiles() []*rotationFile {
 	rfs := map[string]*rotationFile{}
 
-	for _, fPat := range l.Files {
+	env, err := resolveEnviron(l.ExecuteOptions, nil)
+	var files []string
+	if err == nil {
+		files, err = expandEnvs(l.Files, env)
+	}
+	if err != nil {
+		l.logger.Errorf("无法展开 files 字段: %s", err.Error())
+		return nil
+	}
+
+	for _, fPat := range files {
 		matches, _ := filepath.Glob(fPat)
 		for _, match := range matches {
 			filename, _ := filepath.Abs(match)
@@ -101,7 +140,7 @@ func (l *LogrotateRunner) collectRotationFiles() []*rotationFile {
 				rf := rfs[orig]
 				if rf == nil {
 					rf = &rotationFile{original: orig, marks: map[string]bool{}}
-					rfs[filename] = rf
+					rfs[orig] = rf
 				}
 				if mark != "" {
 					rf.marks[mark] = true
@@ -117,11 +156,65 @@ func (l *LogrotateRunner) collectRotationFiles() []*rotationFile {
 	return ret
 }
 
-func (l *LogrotateRunner) rotate() {
-	now := time.Now()
-	bod := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
-	boy := bod.Add(-time.Hour * 24)
-	moy := boy.Format(RotationDailyDateLayout)
+// rotateByTime 按照时间轮转，mark 为上一个时段的标记，对应的 ROT 文件已经存在时，说明已经轮转过
+func (l *LogrotateRunner) rotateByTime(rf *rotationFile, mark string) {
+	foy := rotationMarkAdd(rf.original, mark)
+	if _, err := os.Stat(foy); err == nil {
+		l.logger.Printf("上一时段的文件已经存在: %s", rf.original)
+		return
+	} else if !os.IsNotExist(err) {
+		l.logger.Printf("未知错误: %s: %s", rf.original, err.Error())
+		return
+	}
+	_ = os.Rename(rf.original, foy)
+}
+
+// rotateDailyFilesize 按日或者按照大小轮转，跨越日期或者超过大小时，以文件内容所属的日期和当天的序号标记
+func (l *LogrotateRunner) rotateDailyFilesize(rf *rotationFile, marks []string, now time.Time, lastCheck time.Time) {
+	fi, err := os.Stat(rf.original)
+	if err != nil {
+		l.logger.Printf("无法检测文件: %s: %s", rf.original, err.Error())
+		return
+	}
+	if fi.Size() == 0 {
+		return
+	}
+
+	today := now.Format(RotationDailyDateLayout)
+
+	var day string
+	if !lastCheck.IsZero() {
+		// 上次检查之后跨越了日期
+		if last := lastCheck.Format(RotationDailyDateLayout); last != today {
+			day = last
+		}
+	} else if modDay := fi.ModTime().Format(RotationDailyDateLayout); modDay < today {
+		// 启动后首次检查，文件最后一次写入在今天之前
+		day = modDay
+	}
+	if day == "" && fi.Size() >= int64(l.Size) {
+		day = today
+	}
+	if day == "" {
+		return
+	}
+
+	var id int64
+	for _, mark := range marks {
+		if !strings.HasPrefix(mark, day+"-") {
+			continue
+		}
+		if n, err := strconv.ParseInt(strings.TrimPrefix(mark, day+"-"), 10, 64); err == nil && n > id {
+			id = n
+		}
+	}
+	_ = os.Rename(rf.original, rotationMarkAdd(rf.original, fmt.Sprintf("%s-%06d", day, id+1)))
+}
+
+func (l *LogrotateRunner) rotate(ctx context.Context) {
+	now := l.now()
+	lastCheck := l.lastCheck
+	l.lastCheck = now
 
 	// 遍历所有通配符，建立文件组
 	rfs := l.collectRotationFiles()
@@ -133,16 +226,7 @@ func (l *LogrotateRunner) rotate() {
 			if !ok {
 				continue
 			}
-			switch l.Mode {
-			case RotationModeDaily:
-				if RotationMarkDailyPattern.MatchString(mark) {
-					continue
-				}
-			case RotationModeFilesize:
-				if RotationMarkFilesizePattern.MatchString(mark) {
-					continue
-				}
-			default:
+			if pattern := rotationMarkPatterns[l.Mode]; pattern == nil || pattern.MatchString(mark) {
 				continue
 			}
 			rf.marks[mark] = false
@@ -170,21 +254,17 @@ func (l *LogrotateRunner) rotate() {
 		// 进行轮转
 		switch l.Mode {
 		case RotationModeDaily:
-			foy := rotationMarkAdd(rf.original, moy)
-			if _, err := os.Stat(foy); err == nil {
-				l.logger.Printf("昨日文件已经存在: %s", rf.original)
-				continue
-			} else if !os.IsNotExist(err) {
-				l.logger.Printf("未知错误: %s: %s", rf.original, err.Error())
-				continue
-			}
-			_ = os.Rename(rf.original, rotationMarkAdd(rf.original, moy))
+			l.rotateByTime(rf, now.AddDate(0, 0, -1).Format(RotationDailyDateLayout))
+		case RotationModeHourly:
+			l.rotateByTime(rf, now.Add(-time.Hour).Format(RotationHourlyDateLayout))
+		case RotationModeDailyFilesize:
+			l.rotateDailyFilesize(rf, marks, now, lastCheck)
 		case RotationModeFilesize:
 			if fi, err := os.Stat(rf.original); err != nil {
 				l.logger.Printf("无法检测文件: %s: %s", rf.original, err.Error())
 				continue
 			} else {
-				if fi.Size() < RotationFilesize {
+				if fi.Size() < int64(l.Size) {
 					continue
 				}
 				var id int64
@@ -202,19 +282,49 @@ func (l *LogrotateRunner) rotate() {
 	}
 
 	if len(l.Command) > 0 {
-		_ = execute(l.ExecuteOptions, l.logger)
+		if err := execute(ctx, l.ExecuteOptions, l.logger, nil); err != nil {
+			l.logger.Errorf("命令执行失败: %s", err.Error())
+		}
 	}
 }
 
 func NewLogrotateRunner(unit Unit, logger *mlog.Logger) (Runner, error) {
+	if unit.Mode == "" {
+		unit.Mode = RotationModeDaily
+	}
 	switch unit.Mode {
-	case RotationModeDaily:
-	case RotationModeFilesize:
+	case RotationModeDaily, RotationModeHourly:
+		if unit.Size != 0 {
+			return nil, fmt.Errorf("%s 模式不按照文件大小轮转，检查 size 字段", unit.Mode)
+		}
+	case RotationModeFilesize, RotationModeDailyFilesize:
 	default:
-		return nil, fmt.Errorf("未知的 logrotate 模式: %s", unit.Mode)
+		return nil, fmt.Errorf("未知的 logrotate 模式: %s，检查 mode 字段", unit.Mode)
+	}
+	if unit.Size < 0 {
+		return nil, fmt.Errorf("文件大小不能为负数，检查 size 字段")
+	}
+	if unit.Size == 0 {
+		unit.Size = RotationFilesize
+	}
+	if unit.Keep < 0 {
+		return nil, fmt.Errorf("保留数量不能为负数，检查 keep 字段")
+	}
+	if unit.Cron == "" {
+		unit.Cron = RotationCron
+	}
+	if _, _, err := cronSchedule(unit); err != nil {
+		return nil, err
+	}
+	if err := checkExecuteOptions(unit.ExecuteOptions); err != nil {
+		return nil, err
+	}
+	if err := checkExpandFiles(unit.ExecuteOptions, unit.Files); err != nil {
+		return nil, err
 	}
 	return &LogrotateRunner{
 		Unit:   unit,
 		logger: logger,
+		now:    time.Now,
 	}, nil
 }
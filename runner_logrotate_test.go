@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestRotationMarkRemove(t *testing.T) {
@@ -28,3 +32,62 @@ func TestRotationMarkAdd(t *testing.T) {
 	assert.Equal(t, ".ROT*.hello", rotationMarkAdd(".hello", "*"))
 	assert.Equal(t, "hello.ROT000000000011.log", rotationMarkAdd("hello.log", fmt.Sprintf("%012d", 11)))
 }
+
+func TestNewLogrotateRunner(t *testing.T) {
+	r, err := NewLogrotateRunner(Unit{}, nil)
+	require.NoError(t, err)
+	require.Equal(t, RotationModeDaily, r.(*LogrotateRunner).Mode)
+	require.Equal(t, RotationCron, r.(*LogrotateRunner).Cron)
+
+	_, err = NewLogrotateRunner(Unit{Mode: "weekly"}, nil)
+	require.Error(t, err)
+	_, err = NewLogrotateRunner(Unit{Mode: RotationModeHourly, Size: 1024}, nil)
+	require.Error(t, err)
+	_, err = NewLogrotateRunner(Unit{Size: 1024}, nil)
+	require.Error(t, err)
+	_, err = NewLogrotateRunner(Unit{Mode: RotationModeFilesize, Cron: "not a cron"}, nil)
+	require.Error(t, err)
+
+	r, err = NewLogrotateRunner(Unit{Mode: RotationModeDailyFilesize}, nil)
+	require.NoError(t, err)
+	require.Equal(t, ByteSize(RotationFilesize), r.(*LogrotateRunner).Size)
+}
+
+func TestLogrotateRunnerRotate(t *testing.T) {
+	logger, dir := newTestLogger(t)
+
+	file := filepath.Join(dir, "app.log")
+	// 通配符需要同时匹配已经轮转的文件，才能计算序号
+	pattern := filepath.Join(dir, "app*.log")
+	// 固定当前时间，避免测试跨越整点
+	now := time.Date(2020, 11, 2, 10, 30, 0, 0, time.Local)
+	clock := func() time.Time { return now }
+
+	// hourly
+	require.NoError(t, ioutil.WriteFile(file, []byte("hello\n"), 0644))
+	r, err := NewLogrotateRunner(Unit{Files: []string{pattern}, Mode: RotationModeHourly}, logger)
+	require.NoError(t, err)
+	r.(*LogrotateRunner).now = clock
+	r.(*LogrotateRunner).rotate(context.Background())
+	require.FileExists(t, rotationMarkAdd(file, "2020-11-02-09"))
+	require.NoFileExists(t, file)
+
+	// daily-or-filesize，按照大小轮转
+	require.NoError(t, ioutil.WriteFile(file, []byte("hello\n"), 0644))
+	r, err = NewLogrotateRunner(Unit{Files: []string{pattern}, Mode: RotationModeDailyFilesize, Size: 4}, logger)
+	require.NoError(t, err)
+	lr := r.(*LogrotateRunner)
+	lr.now = clock
+	lr.rotate(context.Background())
+	require.FileExists(t, rotationMarkAdd(file, "2020-11-02-000001"))
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("hi\n"), 0644))
+	lr.rotate(context.Background())
+	require.FileExists(t, file)
+
+	// 跨越日期
+	now = now.AddDate(0, 0, 1)
+	lr.rotate(context.Background())
+	require.NoFileExists(t, file)
+	require.FileExists(t, rotationMarkAdd(file, "2020-11-02-000002"))
+}
@@ -4,6 +4,18 @@ import (
 	"context"
 	"fmt"
 	"github.com/guoyk93/minit/pkg/mlog"
+	"time"
+)
+
+const (
+	OnFailureContinue = "continue"
+	OnFailureAbort    = "abort"
+	OnFailureRetry    = "retry"
+)
+
+const (
+	DefaultRetries    = 3
+	DefaultRetryDelay = time.Second
 )
 
 type OnceRunner struct {
@@ -11,12 +23,45 @@ type OnceRunner struct {
 	logger *mlog.Logger
 }
 
-func (r *OnceRunner) Run(ctx context.Context) {
+func (r *OnceRunner) Run(ctx context.Context, s *UnitStatus) {
 	r.logger.Printf("控制器启动")
 	defer r.logger.Printf("控制器退出")
-	if err := execute(r.ExecuteOptions, r.logger); err != nil {
-		r.logger.Errorf("启动失败: %s", err.Error())
-		return
+
+	backoff := &restartBackoff{
+		initial: r.RetryDelay,
+		max:     r.RetryMaxDelay,
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := execute(ctx, r.ExecuteOptions, r.logger, s.SetPID)
+		s.SetPID(0)
+		if err == nil {
+			return
+		}
+
+		// 手动停止或者 minit 退出造成的失败，不记录为单元失败
+		if ctx.Err() != nil {
+			r.logger.Printf("单元被停止: %s", err.Error())
+			return
+		}
+
+		if attempt > r.Retries {
+			r.logger.Errorf("启动失败: %s", err.Error())
+			s.SetFailed(err)
+			return
+		}
+
+		delay := backoff.next(0)
+		r.logger.Errorf("启动失败: %s，%s 后重试 (%d/%d)", err.Error(), delay.String(), attempt, r.Retries)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			r.logger.Printf("单元被停止，不再重试")
+			return
+		}
 	}
 }
 
@@ -24,6 +69,36 @@ func NewOnceRunner(unit Unit, logger *mlog.Logger) (Runner, error) {
 	if len(unit.Command) == 0 {
 		return nil, fmt.Errorf("没有指定命令，检查 command 字段")
 	}
+	if err := checkExecuteOptions(unit.ExecuteOptions); err != nil {
+		return nil, err
+	}
+	switch unit.OnFailure {
+	case "", OnFailureContinue, OnFailureAbort:
+		if unit.Retries != 0 || unit.RetryDelay != 0 || unit.RetryMaxDelay != 0 {
+			return nil, fmt.Errorf("只有 on_failure 为 retry 时才能重试，检查 retries, retry_delay, retry_max_delay 字段")
+		}
+	case OnFailureRetry:
+		if unit.Retries == 0 {
+			unit.Retries = DefaultRetries
+		}
+	default:
+		return nil, fmt.Errorf("未知的失败处理方式 %s，检查 on_failure 字段", unit.OnFailure)
+	}
+	if unit.Retries < 0 {
+		return nil, fmt.Errorf("重试次数不能为负数，检查 retries 字段")
+	}
+	if unit.RetryDelay < 0 || unit.RetryMaxDelay < 0 {
+		return nil, fmt.Errorf("时间不能为负数，检查 retry_delay, retry_max_delay 字段")
+	}
+	if unit.RetryDelay == 0 {
+		unit.RetryDelay = DefaultRetryDelay
+	}
+	if unit.RetryMaxDelay == 0 {
+		unit.RetryMaxDelay = unit.RetryDelay
+	}
+	if unit.RetryMaxDelay < unit.RetryDelay {
+		return nil, fmt.Errorf("最大重试等待时间不能小于初始重试等待时间，检查 retry_max_delay 字段")
+	}
 	return &OnceRunner{
 		Unit:   unit,
 		logger: logger,
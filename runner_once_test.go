@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewOnceRunner(t *testing.T) {
+	_, err := NewOnceRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}}, OnFailure: "ignore"}, nil)
+	require.Error(t, err)
+	_, err = NewOnceRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}}, OnFailure: OnFailureRetry, Retries: -1}, nil)
+	require.Error(t, err)
+	_, err = NewOnceRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}}, Retries: 2}, nil)
+	require.Error(t, err)
+	_, err = NewOnceRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}}, OnFailure: OnFailureAbort, RetryDelay: time.Second}, nil)
+	require.Error(t, err)
+	r, err := NewOnceRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}}, OnFailure: OnFailureRetry}, nil)
+	require.NoError(t, err)
+	require.Equal(t, DefaultRetries, r.(*OnceRunner).Retries)
+	require.Equal(t, DefaultRetryDelay, r.(*OnceRunner).RetryDelay)
+	require.Equal(t, DefaultRetryDelay, r.(*OnceRunner).RetryMaxDelay)
+}
+
+func TestOnceRunnerRetry(t *testing.T) {
+	logger, _ := newTestLogger(t)
+	r, err := NewOnceRunner(Unit{
+		ExecuteOptions: ExecuteOptions{Command: []string{"false"}},
+		OnFailure:      OnFailureRetry,
+		Retries:        2,
+		RetryDelay:     time.Millisecond,
+	}, logger)
+	require.NoError(t, err)
+	s := NewUnitStatus()
+	r.Run(context.Background(), s)
+	require.Error(t, s.Err())
+	require.Equal(t, 1, exitCodeOf(s.Err()))
+}
+
+func TestOnceRunnerTimeout(t *testing.T) {
+	logger, _ := newTestLogger(t)
+	_, err := NewOnceRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}, Timeout: -time.Second}}, logger)
+	require.Error(t, err)
+	r, err := NewOnceRunner(Unit{
+		ExecuteOptions: ExecuteOptions{
+			Command:     []string{"sleep", "10"},
+			Timeout:     100 * time.Millisecond,
+			StopTimeout: time.Second,
+		},
+	}, logger)
+	require.NoError(t, err)
+	s := NewUnitStatus()
+	start := time.Now()
+	r.Run(context.Background(), s)
+	require.Less(t, int64(time.Since(start)), int64(5*time.Second))
+	var te *TimeoutError
+	require.True(t, errors.As(s.Err(), &te))
+	require.Equal(t, ExitCodeTimeout, exitCodeOf(s.Err()))
+}
+
+func TestOnceRunnerUmask(t *testing.T) {
+	logger, dir := newTestLogger(t)
+	file := filepath.Join(dir, "umask")
+	r, err := NewOnceRunner(Unit{
+		ExecuteOptions: ExecuteOptions{
+			Command: []string{"sh", "-c", "umask > " + file},
+			Umask:   "0027",
+		},
+	}, logger)
+	require.NoError(t, err)
+	s := NewUnitStatus()
+	r.Run(context.Background(), s)
+	require.NoError(t, s.Err())
+	buf, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	require.Equal(t, "0027", strings.TrimSpace(string(buf)))
+
+	// 子进程创建的文件遵循 umask
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0640), info.Mode().Perm())
+}
@@ -7,9 +7,7 @@ import (
 	"github.com/guoyk93/minit/pkg/mlog"
 	"github.com/guoyk93/minit/pkg/tmplfuncs"
 	"io/ioutil"
-	"os"
 	"path/filepath"
-	"strings"
 	"text/template"
 )
 
@@ -18,14 +16,26 @@ type RenderRunner struct {
 	logger *mlog.Logger
 }
 
-func (r *RenderRunner) Run(ctx context.Context) {
+func (r *RenderRunner) Run(ctx context.Context, s *UnitStatus) {
 	r.logger.Printf("控制器启动")
 	defer r.logger.Printf("控制器退出")
 
-	env := environ()
+	env, err := resolveEnviron(r.ExecuteOptions, nil)
+	if err != nil {
+		r.logger.Errorf("无法处理环境变量: %s", err.Error())
+		s.SetFailed(err)
+		return
+	}
+	env = trimEnviron(env)
+
+	files, err := expandEnvs(r.Files, env)
+	if err != nil {
+		r.logger.Errorf("无法展开 files 字段: %s", err.Error())
+		s.SetFailed(err)
+		return
+	}
 
-	for _, filePattern := range r.Files {
-		var err error
+	for _, filePattern := range files {
 		var names []string
 		if names, err = filepath.Glob(filePattern); err != nil {
 			r.logger.Errorf("匹配表达式 %s 格式错误: %s", filePattern, err.Error())
@@ -66,24 +76,15 @@ func NewRenderRunner(unit Unit, logger *mlog.Logger) (Runner, error) {
 	if len(unit.Files) == 0 {
 		return nil, fmt.Errorf("没有指定文件，检查 files 字段")
 	}
+	if err := checkExpandFiles(unit.ExecuteOptions, unit.Files); err != nil {
+		return nil, err
+	}
 	return &RenderRunner{
 		Unit:   unit,
 		logger: logger,
 	}, nil
 }
 
-func environ() map[string]string {
-	out := make(map[string]string)
-	envs := os.Environ()
-	for _, entry := range envs {
-		splits := strings.SplitN(entry, "=", 2)
-		if len(splits) == 2 {
-			out[strings.TrimSpace(splits[0])] = strings.TrimSpace(splits[1])
-		}
-	}
-	return out
-}
-
 func sanitize(s []byte) []byte {
 	lines := bytes.Split(s, []byte("\n"))
 	out := &bytes.Buffer{}
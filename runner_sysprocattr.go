@@ -3,12 +3,51 @@
 package main
 
 import (
+	"fmt"
+	"os"
 	"os/exec"
+	"strconv"
 	"syscall"
 )
 
-func setupCmdSysProcAttr(cmd *exec.Cmd) {
+// umask 是进程级别的设置，为了不影响 minit 自身创建文件以及同时启动的其他进程，
+// 子进程先以 umaskShimName 的名义重新执行 minit，设置 umask 后再执行真正的命令，进程 ID 保持不变
+const umaskShimName = "minit-umask-shim"
+
+func init() {
+	if len(os.Args) < 4 || os.Args[0] != umaskShimName {
+		return
+	}
+	mask, err := strconv.ParseUint(os.Args[1], 8, 32)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "无效的 umask: %s\n", os.Args[1])
+		os.Exit(126)
+	}
+	syscall.Umask(int(mask))
+	err = syscall.Exec(os.Args[2], os.Args[3:], os.Environ())
+	_, _ = fmt.Fprintf(os.Stderr, "无法执行 %s: %s\n", os.Args[2], err.Error())
+	os.Exit(127)
+}
+
+func setupCmdSysProcAttr(cmd *exec.Cmd, cred *Credential) {
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
+	if cred != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:    cred.Uid,
+			Gid:    cred.Gid,
+			Groups: cred.Groups,
+		}
+	}
+}
+
+func signalProcessGroup(pid int, sig syscall.Signal) error {
+	return syscall.Kill(-pid, sig)
+}
+
+// setupCmdUmask 通过 minit 自身包装命令，在子进程中设置 umask，必须在 exec.Command 之后、启动之前调用
+func setupCmdUmask(cmd *exec.Cmd, mask int) {
+	cmd.Args = append([]string{umaskShimName, strconv.FormatUint(uint64(mask), 8), cmd.Path}, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
 }
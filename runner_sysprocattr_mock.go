@@ -2,7 +2,22 @@
 
 package main
 
-import "os/exec"
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
 
-func setupCmdSysProcAttr(cmd *exec.Cmd) {
+func setupCmdSysProcAttr(cmd *exec.Cmd, cred *Credential) {
+}
+
+func signalProcessGroup(pid int, sig syscall.Signal) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Signal(sig)
+}
+
+func setupCmdUmask(cmd *exec.Cmd, mask int) {
 }
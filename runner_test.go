@@ -0,0 +1,18 @@
+package main
+
+import (
+	"github.com/guoyk93/minit/pkg/mlog"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// newTestLogger 在临时目录中创建日志，测试结束时关闭，返回日志以及所在的目录
+func newTestLogger(t *testing.T) (logger *mlog.Logger, dir string) {
+	dir = t.TempDir()
+	logger, err := mlog.NewLogger(dir, "test", "test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = logger.Close()
+	})
+	return
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/guoyk93/minit/pkg/mlog"
+	"path/filepath"
+	"time"
+)
+
+type TimerRunner struct {
+	Unit
+	logger  *mlog.Logger
+	trigger chan struct{}
+	history *runHistory
+}
+
+func (r *TimerRunner) Run(ctx context.Context, s *UnitStatus) {
+	r.logger.Printf("控制器启动")
+	defer r.logger.Printf("控制器退出")
+
+	// 丢弃上次运行期间未处理的手动触发
+	select {
+	case <-r.trigger:
+	default:
+	}
+
+	if err := r.history.load(); err != nil {
+		r.logger.Errorf("无法读取状态文件: %s", err.Error())
+	}
+
+	s.SetReady()
+
+	delay := r.InitialDelay
+	for {
+		delay += randomDuration(r.Jitter)
+
+		trigger := RunTriggerSchedule
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-r.trigger:
+			timer.Stop()
+			r.logger.Printf("手动触发定时任务")
+			trigger = RunTriggerManual
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		r.runOnce(ctx, s, trigger)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// 下次执行的时间从本次执行结束开始计算
+		delay = r.Interval
+	}
+}
+
+// runOnce 执行一次命令，并记录执行结果
+func (r *TimerRunner) runOnce(ctx context.Context, s *UnitStatus, trigger string) {
+	r.logger.Printf("定时任务触发")
+
+	start := time.Now()
+	err := execute(ctx, r.ExecuteOptions, r.logger, s.SetPID)
+	s.SetPID(0)
+
+	if err1 := r.history.add(newRunRecord(start, trigger, err)); err1 != nil {
+		r.logger.Errorf("无法保存状态文件: %s", err1.Error())
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			r.logger.Printf("定时任务被停止: %s", err.Error())
+		} else {
+			r.logger.Errorf("定时任务失败: %s", err.Error())
+		}
+		return
+	}
+	r.logger.Printf("定时任务结束")
+}
+
+func (r *TimerRunner) History() []RunRecord {
+	return r.history.records()
+}
+
+func (r *TimerRunner) Trigger() error {
+	select {
+	case r.trigger <- struct{}{}:
+		return nil
+	default:
+		return errors.New("已经有等待执行的手动触发")
+	}
+}
+
+func NewTimerRunner(unit Unit, logger *mlog.Logger) (Runner, error) {
+	if len(unit.Command) == 0 {
+		return nil, fmt.Errorf("没有指定命令，检查 command 字段")
+	}
+	if err := checkExecuteOptions(unit.ExecuteOptions); err != nil {
+		return nil, err
+	}
+	if unit.Interval <= 0 {
+		return nil, fmt.Errorf("没有指定执行间隔，检查 interval 字段")
+	}
+	if unit.InitialDelay < 0 || unit.Jitter < 0 {
+		return nil, fmt.Errorf("时间不能为负数，检查 initial_delay, jitter 字段")
+	}
+	if unit.HistoryLimit < 0 {
+		return nil, fmt.Errorf("执行记录数量不能为负数，检查 history_limit 字段")
+	}
+	if unit.HistoryLimit == 0 {
+		unit.HistoryLimit = DefaultHistoryLimit
+	}
+	var historyPath string
+	if unit.HistoryPersist {
+		historyPath = filepath.Join(optLogDir, unit.Name+".timer.json")
+	}
+	return &TimerRunner{
+		Unit:    unit,
+		logger:  logger,
+		trigger: make(chan struct{}, 1),
+		history: newRunHistory(historyPath, unit.HistoryLimit),
+	}, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNewTimerRunner(t *testing.T) {
+	_, err := NewTimerRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}}}, nil)
+	require.Error(t, err)
+	_, err = NewTimerRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}}, Interval: time.Second, Jitter: -time.Second}, nil)
+	require.Error(t, err)
+	r, err := NewTimerRunner(Unit{ExecuteOptions: ExecuteOptions{Command: []string{"true"}}, Interval: time.Second}, nil)
+	require.NoError(t, err)
+	require.Equal(t, DefaultHistoryLimit, r.(*TimerRunner).HistoryLimit)
+}
+
+func TestTimerRunner(t *testing.T) {
+	logger, _ := newTestLogger(t)
+	r, err := NewTimerRunner(Unit{
+		Name:           "test-timer",
+		ExecuteOptions: ExecuteOptions{Command: []string{"sleep", "0.1"}},
+		Interval:       100 * time.Millisecond,
+	}, logger)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(ctx, NewUnitStatus())
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(r.(HistoryRunner).History()) >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	records := r.(HistoryRunner).History()
+	require.Equal(t, RunTriggerSchedule, records[0].Trigger)
+	require.Equal(t, 0, records[0].ExitCode)
+	// 下次执行从上次执行结束开始计算
+	require.True(t, records[1].Start.Sub(records[0].Start.Add(records[0].Duration)) >= 100*time.Millisecond)
+}
+
+func TestTimerRunnerTrigger(t *testing.T) {
+	logger, _ := newTestLogger(t)
+	r, err := NewTimerRunner(Unit{
+		Name:           "test-timer-trigger",
+		ExecuteOptions: ExecuteOptions{Command: []string{"true"}},
+		Interval:       time.Hour,
+		InitialDelay:   time.Hour,
+	}, logger)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s := NewUnitStatus()
+	go func() {
+		defer close(done)
+		r.Run(ctx, s)
+	}()
+	<-s.Ready()
+
+	require.NoError(t, r.(TriggerRunner).Trigger())
+	require.Eventually(t, func() bool {
+		return len(r.(HistoryRunner).History()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	require.Equal(t, RunTriggerManual, r.(HistoryRunner).History()[0].Trigger)
+}
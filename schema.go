@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// schemaField 单元配置中的字段，kind 标签指定字段适用的单元类型，多个类型以逗号分隔，没有 kind 标签的字段适用于所有类型
+type schemaField struct {
+	Name  string
+	Type  reflect.Type
+	Kinds []string
+}
+
+// appliesTo 检查字段是否适用于指定类型的单元
+func (f schemaField) appliesTo(kind string) bool {
+	if len(f.Kinds) == 0 {
+		return true
+	}
+	for _, k := range f.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaFields 按照 yaml 标签列出结构体的字段，展开 inline 字段
+func schemaFields(t reflect.Type) (fields []schemaField) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(sf.Tag.Get("yaml"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		if len(tag) > 1 && tag[1] == "inline" {
+			fields = append(fields, schemaFields(sf.Type)...)
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+		field := schemaField{Name: name, Type: sf.Type}
+		if kinds := sf.Tag.Get("kind"); kinds != "" {
+			field.Kinds = strings.Split(kinds, ",")
+		}
+		fields = append(fields, field)
+	}
+	return
+}
+
+// isSchemaStruct 检查类型是否为需要逐个检查字段的结构体
+func isSchemaStruct(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != reflect.TypeOf(time.Time{})
+}
+
+// SchemaError 单元配置中的字段错误，包含行号和列号
+type SchemaError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("第 %d 行第 %d 列: %s", e.Line, e.Column, e.Message)
+}
+
+// suggestField 查找与 name 最接近的字段名，用于提示拼写错误
+func suggestField(name string, fields []schemaField) string {
+	var (
+		best     string
+		bestDist = 3
+	)
+	for _, f := range fields {
+		if d := editDistance(name, f.Name); d < bestDist {
+			best, bestDist = f.Name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// resolveAlias 返回别名指向的节点
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// isMergeKey 检查是否为合并键 <<
+func isMergeKey(key *yaml.Node) bool {
+	return key.Kind == yaml.ScalarNode && key.Value == "<<" && (key.Tag == "" || key.Tag == "!!merge")
+}
+
+// mergedNodes 返回合并键引用的 mapping 节点，值可以是单个 mapping 或者 mapping 的列表
+func mergedNodes(val *yaml.Node) (nodes []*yaml.Node) {
+	val = resolveAlias(val)
+	if val.Kind == yaml.SequenceNode {
+		for _, item := range val.Content {
+			nodes = append(nodes, resolveAlias(item))
+		}
+		return
+	}
+	return []*yaml.Node{val}
+}
+
+// checkSchemaNode 检查 mapping 节点中的字段，kind 不为空时，同时检查字段是否适用于该类型的单元，prefix 为上级字段名
+//
+// 合并键 << 引用的字段与直接写出的字段一样检查
+func checkSchemaNode(node *yaml.Node, t reflect.Type, kind string, prefix string) (errs []error) {
+	node = resolveAlias(node)
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	fields := schemaFields(t)
+	byName := map[string]schemaField{}
+	for _, f := range fields {
+		byName[f.Name] = f
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, val := node.Content[i], node.Content[i+1]
+		if isMergeKey(key) {
+			for _, merged := range mergedNodes(val) {
+				errs = append(errs, checkSchemaNode(merged, t, kind, prefix)...)
+			}
+			continue
+		}
+		f, ok := byName[key.Value]
+		if !ok {
+			msg := fmt.Sprintf("未知字段 %s%s", prefix, key.Value)
+			if s := suggestField(key.Value, fields); s != "" {
+				msg += fmt.Sprintf("，是否为 %s%s", prefix, s)
+			}
+			errs = append(errs, &SchemaError{Line: key.Line, Column: key.Column, Message: msg})
+			continue
+		}
+		if kind != "" && !f.appliesTo(kind) {
+			errs = append(errs, &SchemaError{
+				Line:    key.Line,
+				Column:  key.Column,
+				Message: fmt.Sprintf("字段 %s%s 不适用于 %s 单元，只适用于 %s", prefix, key.Value, kind, strings.Join(f.Kinds, ", ")),
+			})
+			continue
+		}
+		if isSchemaStruct(f.Type) {
+			errs = append(errs, checkSchemaNode(val, f.Type, "", prefix+key.Value+".")...)
+		}
+	}
+	return
+}
+
+// unitNodeKind 查找单元文档中的 kind 字段，直接写出的字段优先于合并键引用的字段
+func unitNodeKind(node *yaml.Node) (kind string, ok bool) {
+	var merged []*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, val := node.Content[i], node.Content[i+1]
+		if isMergeKey(key) {
+			merged = append(merged, mergedNodes(val)...)
+		} else if key.Value == "kind" {
+			return strings.TrimSpace(resolveAlias(val).Value), true
+		}
+	}
+	for _, m := range merged {
+		if m.Kind != yaml.MappingNode {
+			continue
+		}
+		if kind, ok = unitNodeKind(m); ok {
+			return
+		}
+	}
+	return
+}
+
+// checkUnitNode 检查单元文档中的字段，未知类型的单元只检查字段名
+func checkUnitNode(node *yaml.Node) []error {
+	kind, _ := unitNodeKind(node)
+	if RunnerFactories[kind] == nil {
+		kind = ""
+	}
+	return checkSchemaNode(node, reflect.TypeOf(Unit{}), kind, "")
+}
+
+// jsonSchemaOf 生成类型对应的 JSON Schema
+func jsonSchemaOf(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeOf(time.Duration(0)):
+		// 与 yaml 解析一致，接受 0 以及以纳秒为单位的整数
+		return map[string]interface{}{"type": []string{"string", "integer"}, "pattern": `^(0|([0-9.]+(ns|us|µs|ms|s|m|h))+)$`}
+	case reflect.TypeOf(ByteSize(0)):
+		return map[string]interface{}{"type": []string{"string", "integer"}}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": jsonSchemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchemaOf(t.Elem())}
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		for _, f := range schemaFields(t) {
+			props[f.Name] = jsonSchemaOf(f.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": props, "additionalProperties": false}
+	}
+	return map[string]interface{}{}
+}
+
+// UnitJSONSchema 生成单元配置的 JSON Schema，字段是否可用取决于 kind 字段
+func UnitJSONSchema() map[string]interface{} {
+	schema := jsonSchemaOf(reflect.TypeOf(Unit{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "minit unit"
+	schema["required"] = []string{"kind", "name"}
+
+	var kinds []string
+	for kind := range RunnerFactories {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	props := schema["properties"].(map[string]interface{})
+	props["kind"] = map[string]interface{}{"type": "string", "enum": kinds}
+	// group 字段用于单元分组，运行进程的组通过 user 字段指定
+	props["user"] = map[string]interface{}{
+		"type":        "string",
+		"pattern":     `^[^:]+(:[^:]+)?$`,
+		"description": "运行进程的用户和组，格式为 user 或者 user:group，名称或者数字 ID，组默认为用户的主组",
+	}
+
+	// 每种类型的单元，禁止不适用的字段
+	var rules []interface{}
+	for _, kind := range kinds {
+		forbidden := map[string]interface{}{}
+		for _, f := range schemaFields(reflect.TypeOf(Unit{})) {
+			if !f.appliesTo(kind) {
+				forbidden[f.Name] = false
+			}
+		}
+		rules = append(rules, map[string]interface{}{
+			"if":   map[string]interface{}{"properties": map[string]interface{}{"kind": map[string]interface{}{"const": kind}}},
+			"then": map[string]interface{}{"properties": forbidden},
+		})
+	}
+	schema["allOf"] = rules
+	return schema
+}
+
+func runSchemaCommand(args []string) (err error) {
+	fs := newSubcommandFlagSet("schema", "schema")
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(UnitJSONSchema())
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeUnitDocumentsSchema(t *testing.T) {
+	docs, err := decodeUnitDocuments(strings.NewReader(`kind: daemon
+name: typo
+comand: ["true"]
+readiness:
+  intervl: 1s
+---
+kind: daemon
+name: mode
+mode: parallel
+command: [sleep, "60"]
+---
+kind: once
+name: bad-type
+command: ["true"]
+log:
+  max_files: abc
+`))
+	require.NoError(t, err)
+	require.Len(t, docs, 3)
+
+	require.Len(t, docs[0].Errs, 2)
+	require.Equal(t, "第 3 行第 1 列: 未知字段 comand，是否为 command", docs[0].Errs[0].Error())
+	require.Equal(t, "第 5 行第 3 列: 未知字段 readiness.intervl，是否为 readiness.interval", docs[0].Errs[1].Error())
+
+	require.Len(t, docs[1].Errs, 1)
+	require.Contains(t, docs[1].Errs[0].Error(), "第 9 行第 1 列: 字段 mode 不适用于 daemon 单元")
+
+	require.Len(t, docs[2].Errs, 1)
+	require.Contains(t, docs[2].Errs[0].Error(), "第 16 行")
+}
+
+func TestDecodeUnitDocumentsValues(t *testing.T) {
+	// 字段的值与 yaml.v2 的解析结果一致，支持合并键和别名
+	docs, err := decodeUnitDocuments(strings.NewReader(`kind: daemon
+name: app
+critical: yes
+stop_timeout: 0
+restart_delay: 1000000000
+<<: {restart: on-failure, max_restarts: 3}
+readiness: &probe
+  exec: ["true"]
+  interval: 2s
+liveness: *probe
+command: [sleep, "60"]
+---
+kind: daemon
+name: merged
+<<: [{kind: once}, {restrat: always}]
+command: ["true"]
+restart: always
+`))
+	require.NoError(t, err)
+	require.Len(t, docs, 2)
+
+	require.Empty(t, docs[0].Errs)
+	unit := docs[0].Unit
+	require.True(t, unit.Critical)
+	require.Equal(t, time.Duration(0), unit.StopTimeout)
+	require.Equal(t, time.Second, unit.RestartDelay)
+	require.Equal(t, "on-failure", unit.Restart)
+	require.Equal(t, 3, unit.MaxRestarts)
+	require.Equal(t, []string{"true"}, unit.Readiness.Exec)
+	require.Equal(t, time.Second*2, unit.Liveness.Interval)
+
+	// 合并键引用的字段同样检查，直接写出的 kind 优先
+	require.Len(t, docs[1].Errs, 1)
+	require.Equal(t, "第 15 行第 21 列: 未知字段 restrat，是否为 restart", docs[1].Errs[0].Error())
+}
+
+func TestUnitJSONSchema(t *testing.T) {
+	schema := UnitJSONSchema()
+	require.Equal(t, false, schema["additionalProperties"])
+	props := schema["properties"].(map[string]interface{})
+	require.Contains(t, props, "command")
+	require.Contains(t, props, "log")
+	pattern := regexp.MustCompile(props["stop_timeout"].(map[string]interface{})["pattern"].(string))
+	require.True(t, pattern.MatchString("0"))
+	require.True(t, pattern.MatchString("1m30s"))
+	require.False(t, pattern.MatchString("10"))
+
+	user := props["user"].(map[string]interface{})
+	require.Contains(t, user["description"], "user:group")
+	pattern = regexp.MustCompile(user["pattern"].(string))
+	require.True(t, pattern.MatchString("app"))
+	require.True(t, pattern.MatchString("1000:1000"))
+	require.False(t, pattern.MatchString("app:app:app"))
+
+	var daemonRule map[string]interface{}
+	for _, rule := range schema["allOf"].([]interface{}) {
+		rule := rule.(map[string]interface{})
+		kind := rule["if"].(map[string]interface{})["properties"].(map[string]interface{})["kind"].(map[string]interface{})["const"]
+		if kind == "daemon" {
+			daemonRule = rule["then"].(map[string]interface{})["properties"].(map[string]interface{})
+		}
+	}
+	require.NotNil(t, daemonRule)
+	require.Contains(t, daemonRule, "mode")
+	require.NotContains(t, daemonRule, "command")
+}
+
+func TestSchemaSubcommand(t *testing.T) {
+	// minit schema 是顶层子命令，不需要 ctl 前缀
+	name, args, ok := findSubcommand([]string{"schema"}, false)
+	require.True(t, ok)
+	require.Equal(t, "schema", name)
+
+	out, err := ioutil.TempFile("", "minit-schema")
+	require.NoError(t, err)
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	defer func(v *os.File) { os.Stdout = v }(os.Stdout)
+	os.Stdout = out
+	require.NoError(t, Subcommands[name](args))
+
+	buf, err := ioutil.ReadFile(out.Name())
+	require.NoError(t, err)
+	var schema map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf, &schema))
+	require.Equal(t, "minit unit", schema["title"])
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	UnitPending = "pending" // 等待依赖单元
+	UnitRunning = "running" // 正在运行
+	UnitReady   = "ready"   // 已经就绪
+	UnitExited  = "exited"  // 已经结束
+	UnitFailed  = "failed"  // 运行失败
+	UnitSkipped = "skipped" // 依赖单元失败，没有启动
+	UnitStopped = "stopped" // 已经停止
+)
+
+// UnitStatus 单元运行状态，由控制器更新，用于依赖单元之间的等待
+type UnitStatus struct {
+	phase    string
+	err      error
+	since    time.Time
+	pid      int
+	restarts int
+
+	ready chan struct{}
+	done  chan struct{}
+
+	l sync.Locker
+}
+
+func NewUnitStatus() *UnitStatus {
+	return &UnitStatus{
+		phase: UnitPending,
+		since: time.Now(),
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+		l:     &sync.Mutex{},
+	}
+}
+
+// reset 重置已经结束的单元状态，用于再次启动单元
+func (s *UnitStatus) reset() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.phase = UnitPending
+	s.err = nil
+	s.since = time.Now()
+	s.pid = 0
+	s.restarts = 0
+	s.ready = make(chan struct{})
+	s.done = make(chan struct{})
+}
+
+// SetReady 标记单元已经就绪，依赖此单元的单元可以启动
+func (s *UnitStatus) SetReady() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	select {
+	case <-s.ready:
+		return
+	default:
+	}
+	if s.phase == UnitRunning {
+		s.phase = UnitReady
+		s.since = time.Now()
+	}
+	close(s.ready)
+}
+
+// SetFailed 标记单元运行失败
+func (s *UnitStatus) SetFailed(err error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.err = err
+}
+
+// SetPID 记录当前运行的进程，进程退出后设置为 0
+func (s *UnitStatus) SetPID(pid int) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.pid = pid
+}
+
+// AddRestart 记录一次重启
+func (s *UnitStatus) AddRestart() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.restarts++
+}
+
+func (s *UnitStatus) setPhase(phase string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.phase = phase
+	s.since = time.Now()
+}
+
+// finish 标记单元结束，如果运行失败，则状态为 failed
+func (s *UnitStatus) finish(phase string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.err != nil && phase != UnitSkipped {
+		s.phase = UnitFailed
+	} else {
+		s.phase = phase
+	}
+	s.since = time.Now()
+	s.pid = 0
+	close(s.done)
+}
+
+func (s *UnitStatus) Phase() string {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.phase
+}
+
+func (s *UnitStatus) Err() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.err
+}
+
+// Ready 单元就绪时关闭
+func (s *UnitStatus) Ready() <-chan struct{} {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.ready
+}
+
+// Done 单元结束时关闭
+func (s *UnitStatus) Done() <-chan struct{} {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.done
+}
+
+// UnitStatusSnapshot 单元状态快照，用于控制接口
+type UnitStatusSnapshot struct {
+	Name     string     `json:"name"`
+	Kind     string     `json:"kind"`
+	Group    string     `json:"group,omitempty"`
+	Phase    string     `json:"phase"`
+	Since    time.Time  `json:"since"`
+	PID      int        `json:"pid,omitempty"`
+	Restarts int        `json:"restarts,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	LastRun  *RunRecord `json:"last_run,omitempty"`
+}
+
+func (s *UnitStatus) snapshot(unit Unit) UnitStatusSnapshot {
+	s.l.Lock()
+	defer s.l.Unlock()
+	ss := UnitStatusSnapshot{
+		Name:     unit.Name,
+		Kind:     unit.Kind,
+		Group:    unit.Group,
+		Phase:    s.phase,
+		Since:    s.since,
+		PID:      s.pid,
+		Restarts: s.restarts,
+	}
+	if s.err != nil {
+		ss.Error = s.err.Error()
+	}
+	return ss
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"github.com/guoyk93/minit/pkg/mlog"
+	"os"
+	"path/filepath"
+)
+
+// validateUnit 待检查的单元，以及单元的来源
+type validateUnit struct {
+	Unit
+	source string
+}
+
+// validateProblem 检查发现的问题
+type validateProblem struct {
+	source  string
+	message string
+}
+
+func (p validateProblem) String() string {
+	if p.source == "" {
+		return p.message
+	}
+	return p.source + ": " + p.message
+}
+
+// validateSource 单元来源的描述，比如 "/etc/minit.d/app.yml 第 2 个文档"
+func validateSource(file string, index int) string {
+	return fmt.Sprintf("%s 第 %d 个文档", file, index)
+}
+
+// loadValidateFile 逐个文档载入文件中的单元，不按照 MINIT_ENABLE 和 MINIT_DISABLE 过滤
+func loadValidateFile(fn string) (units []validateUnit, problems []validateProblem) {
+	f, err := os.Open(fn)
+	if err != nil {
+		problems = append(problems, validateProblem{source: fn, message: err.Error()})
+		return
+	}
+	defer f.Close()
+
+	docs, err := decodeUnitDocuments(f)
+	for _, doc := range docs {
+		source := validateSource(fn, doc.Index)
+		if len(doc.Errs) > 0 {
+			for _, err := range doc.Errs {
+				problems = append(problems, validateProblem{source: source, message: err.Error()})
+			}
+			continue
+		}
+		for _, unit := range expandUnit(doc.Unit) {
+			units = append(units, validateUnit{Unit: unit, source: source})
+		}
+	}
+	if err != nil {
+		problems = append(problems, validateProblem{source: fn, message: fmt.Sprintf("无法解析: %s", err.Error())})
+	}
+	return
+}
+
+// validateUnits 检查单元目录，以及 MINIT_MAIN 环境变量定义的单元，返回发现的所有问题
+func validateUnits(dir string) (count int, problems []validateProblem) {
+	var units []validateUnit
+
+	if fi, err := os.Stat(dir); err != nil {
+		problems = append(problems, validateProblem{message: fmt.Sprintf("无法读取单元目录: %s", err.Error())})
+		return
+	} else if !fi.IsDir() {
+		problems = append(problems, validateProblem{message: fmt.Sprintf("%s 不是目录", dir)})
+		return
+	}
+
+	for _, ext := range []string{"*.yml", "*.yaml"} {
+		files, _ := filepath.Glob(filepath.Join(dir, ext))
+		for _, file := range files {
+			units0, problems0 := loadValidateFile(file)
+			units = append(units, units0...)
+			problems = append(problems, problems0...)
+		}
+	}
+
+	if unit, ok, err := LoadEnvMain(); err != nil {
+		problems = append(problems, validateProblem{source: "环境变量 MINIT_MAIN", message: err.Error()})
+	} else if ok {
+		units = append(units, validateUnit{Unit: unit, source: "环境变量 MINIT_MAIN"})
+	}
+
+	// 检查名称和控制器，通过检查的单元用于检查依赖关系
+	var (
+		valid  []Unit
+		levels []RunnerLevel
+		names  = map[string]string{"minit": "minit 保留名称"}
+	)
+	for _, unit := range units {
+		if err := checkUnitName(unit.Name); err != nil {
+			problems = append(problems, validateProblem{source: unit.source, message: err.Error()})
+			continue
+		}
+		if source, ok := names[unit.Name]; ok {
+			problems = append(problems, validateProblem{
+				source:  unit.source,
+				message: fmt.Sprintf("单元名称 %s 重复出现，与 %s 冲突，检查 name 字段", unit.Name, source),
+			})
+			continue
+		}
+		names[unit.Name] = unit.source
+
+		level, err := validateRunner(unit.Unit)
+		if err != nil {
+			problems = append(problems, validateProblem{source: unit.source, message: err.Error()})
+			continue
+		}
+		valid = append(valid, unit.Unit)
+		levels = append(levels, level)
+	}
+
+	if _, _, err := BuildDependencies(valid, levels); err != nil {
+		problems = append(problems, validateProblem{message: err.Error()})
+	}
+
+	count = len(units)
+	return
+}
+
+// validateRunner 检查日志配置，并创建控制器，不会写入日志文件
+func validateRunner(unit Unit) (level RunnerLevel, err error) {
+	fac := RunnerFactories[unit.Kind]
+	if fac == nil {
+		err = fmt.Errorf("单元 %s 类型 %s 未知，检查 kind 字段", unit.Name, unit.Kind)
+		return
+	}
+	if _, err = unit.Log.loggerOptions(optLogDefaults); err != nil {
+		return
+	}
+	var logger *mlog.Logger
+	if logger, err = mlog.NewLoggerWithOptions(mlog.LoggerOptions{
+		Name:           unit.Name,
+		Kind:           unit.Kind,
+		DisableFile:    true,
+		DisableConsole: true,
+	}); err != nil {
+		return
+	}
+	if _, err = fac.Create(unit, logger); err != nil {
+		err = fmt.Errorf("单元 %s 无效: %s", unit.Name, err.Error())
+		return
+	}
+	level = fac.Level
+	return
+}
+
+func runValidateCommand(args []string) (err error) {
+	fs := newSubcommandFlagSet("validate", "validate [--unit-dir DIR]")
+	fs.StringVar(&optUnitDir, "unit-dir", optUnitDir, "配置单元目录")
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+
+	count, problems := validateUnits(optUnitDir)
+	for _, problem := range problems {
+		_, _ = fmt.Fprintln(os.Stderr, problem.String())
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("发现 %d 个问题", len(problems))
+	}
+	fmt.Printf("检查通过，共 %d 个单元\n", count)
+	return
+}
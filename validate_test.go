@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateUnits(t *testing.T) {
+	count, problems := validateUnits(filepath.Join("testdata", "validate"))
+	require.Equal(t, 6, count)
+
+	var messages []string
+	for _, problem := range problems {
+		messages = append(messages, problem.String())
+	}
+	file := filepath.Join("testdata", "validate", "units.yml")
+	require.Len(t, messages, 5)
+	require.Contains(t, messages[0], file+" 第 2 个文档: 单元 bad-cron 无效")
+	require.Contains(t, messages[1], file+" 第 3 个文档: 单元名称 app 重复出现")
+	require.Contains(t, messages[2], file+" 第 4 个文档: 单元 unknown-kind 类型 bogus 未知")
+	require.Contains(t, messages[3], file+" 第 5 个文档: 单元名称 1bad 不符合规则")
+	require.Contains(t, messages[4], "依赖的单元 ghost 不存在")
+
+	count, problems = validateUnits(filepath.Join("testdata", "minit.d"))
+	require.NotZero(t, count)
+	require.Empty(t, problems)
+}
+
+func TestValidateSubcommand(t *testing.T) {
+	defer func(v string) { optUnitDir = v }(optUnitDir)
+
+	// minit validate 是顶层子命令，不需要 ctl 前缀
+	name, args, ok := findSubcommand([]string{"validate", "--unit-dir", filepath.Join("testdata", "minit.d")}, false)
+	require.True(t, ok)
+	require.Equal(t, "validate", name)
+	require.NoError(t, Subcommands[name](args))
+
+	name, args, ok = findSubcommand([]string{"validate", "--unit-dir", filepath.Join("testdata", "validate")}, false)
+	require.True(t, ok)
+	require.Error(t, Subcommands[name](args))
+
+	// "--" 之后的 validate 作为主程序
+	_, _, ok = findSubcommand([]string{"validate"}, true)
+	require.False(t, ok)
+}
@@ -0,0 +1,72 @@
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"time"
+)
+
+const (
+	// WatchUnitDirDelay 单元目录最后一次变化后，等待此时间再重新载入，合并连续的变化
+	WatchUnitDirDelay = time.Second
+
+	watchUnitDirMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE |
+		unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_ATTRIB
+)
+
+// WatchUnitDir 使用 inotify 监视单元目录，目录中的文件发生变化时，返回的 chan 收到通知
+func WatchUnitDir(dir string) (ch <-chan struct{}, err error) {
+	var fd int
+	if fd, err = unix.InotifyInit1(unix.IN_CLOEXEC); err != nil {
+		err = fmt.Errorf("无法监视单元目录 %s: %s", dir, err.Error())
+		return
+	}
+	if _, err = unix.InotifyAddWatch(fd, dir, watchUnitDirMask); err != nil {
+		_ = unix.Close(fd)
+		err = fmt.Errorf("无法监视单元目录 %s: %s", dir, err.Error())
+		return
+	}
+
+	log.Printf("监视单元目录: %s", dir)
+
+	events := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, unix.SizeofInotifyEvent*64+unix.PathMax)
+		for {
+			n, err := unix.Read(fd, buf)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil || n <= 0 {
+				log.Errorf("监视单元目录退出: %v", err)
+				return
+			}
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	out := make(chan struct{}, 1)
+	go func() {
+		timer := time.NewTimer(WatchUnitDirDelay)
+		timer.Stop()
+		for {
+			select {
+			case <-events:
+				timer.Reset(WatchUnitDirDelay)
+			case <-timer.C:
+				select {
+				case out <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	ch = out
+	return
+}
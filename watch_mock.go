@@ -0,0 +1,10 @@
+//+build !linux
+
+package main
+
+import "errors"
+
+func WatchUnitDir(dir string) (ch <-chan struct{}, err error) {
+	err = errors.New("当前系统不支持监视单元目录")
+	return
+}